@@ -0,0 +1,70 @@
+package bolus
+
+import "time"
+
+type CarbsOnBoardInput struct {
+	// Previous Meals (Meals older than their absorption time are ignored)
+	Meals []Meal
+	// How the Carbohydrates of a Meal are absorbed over time (defaults to LinearCarbAbsorption)
+	CarbAbsorptionModel CarbAbsorptionModel
+}
+
+type Meal struct {
+	// Time the Meal was eaten
+	Time time.Time `json:"time"`
+	// Grams of Carbohydrates counted for the Meal
+	GramsOfCarbs float32 `json:"grams_of_carbs"`
+	// Minutes for the Carbohydrates to be fully absorbed (0 for DefaultCarbAbsorptionTime)
+	AbsorptionTimeInMinutes float32 `json:"absorption_time_in_minutes"`
+}
+
+// AbsorptionTime returns the time for the Meal to be fully absorbed
+func (m Meal) AbsorptionTime() time.Duration {
+	if m.AbsorptionTimeInMinutes <= 0 {
+		return DefaultCarbAbsorptionTime
+	}
+	return time.Duration(m.AbsorptionTimeInMinutes * float32(time.Minute))
+}
+
+// Absorption time of a Meal when none is given
+const DefaultCarbAbsorptionTime = 3 * time.Hour
+
+// Longest absorption time allowed for a Meal, after which no Meal has Carbs On Board
+const MaxCarbAbsorptionTime = 8 * time.Hour
+
+type CarbAbsorptionModel string
+
+const (
+	// Carbohydrates are absorbed at a constant rate
+	LinearCarbAbsorption CarbAbsorptionModel = "linear"
+	// Absorption ramps up over the first 15% of the absorption time, holds until 50%, then tapers
+	// off, as in Loop's PiecewiseLinearAbsorption
+	PiecewiseCarbAbsorption CarbAbsorptionModel = "piecewise"
+)
+
+// FractionAbsorbed returns the fraction of a Meal's Carbohydrates absorbed after the given time
+// has elapsed, from 0 up to 1
+func (m CarbAbsorptionModel) FractionAbsorbed(elapsed time.Duration, absorptionTime time.Duration) float32 {
+	if elapsed <= 0 {
+		return 0
+	}
+	if elapsed >= absorptionTime {
+		return 1
+	}
+
+	t := float32(elapsed) / float32(absorptionTime)
+	if m != PiecewiseCarbAbsorption {
+		return t
+	}
+
+	const endOfRise, startOfFall = 0.15, 0.5
+	const scale = 2 / (1 + startOfFall - endOfRise)
+	switch {
+	case t < endOfRise:
+		return 0.5 * scale * t * t / endOfRise
+	case t < startOfFall:
+		return scale * (t - endOfRise/2)
+	default:
+		return scale * (startOfFall - endOfRise/2 + (t-startOfFall)*(1-0.5*(t-startOfFall)/(1-startOfFall)))
+	}
+}
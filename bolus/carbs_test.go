@@ -0,0 +1,38 @@
+package bolus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCarbAbsorptionModel(t *testing.T) {
+	absorptionTime := 3 * time.Hour
+	for _, model := range []CarbAbsorptionModel{LinearCarbAbsorption, PiecewiseCarbAbsorption} {
+		if model.FractionAbsorbed(-time.Minute, absorptionTime) != 0 {
+			t.Errorf("%s: expected nothing absorbed before the meal", model)
+		}
+		if model.FractionAbsorbed(absorptionTime, absorptionTime) != 1 {
+			t.Errorf("%s: expected all absorbed after the absorption time", model)
+		}
+
+		previous := float32(0)
+		for elapsed := 5 * time.Minute; elapsed < absorptionTime; elapsed += 5 * time.Minute {
+			fraction := model.FractionAbsorbed(elapsed, absorptionTime)
+			if fraction <= previous || fraction > 1 {
+				t.Fatalf("%s: expected absorption to increase, got %f after %f at %s", model, fraction, previous, elapsed)
+			}
+			previous = fraction
+		}
+	}
+
+	if fraction := LinearCarbAbsorption.FractionAbsorbed(90*time.Minute, absorptionTime); fraction != 0.5 {
+		t.Errorf("expected 0.5, got %f", fraction)
+	}
+	// Absorption is slowest at the start and end of the absorption time
+	if fraction := PiecewiseCarbAbsorption.FractionAbsorbed(18*time.Minute, absorptionTime); fraction >= 0.1 {
+		t.Errorf("expected under 0.1, got %f", fraction)
+	}
+	if fraction := PiecewiseCarbAbsorption.FractionAbsorbed(162*time.Minute, absorptionTime); fraction <= 0.9 {
+		t.Errorf("expected over 0.9, got %f", fraction)
+	}
+}
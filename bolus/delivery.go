@@ -0,0 +1,90 @@
+package bolus
+
+import "math"
+
+type DeliveryDevice string
+
+const (
+	HalfUnitPen  DeliveryDevice = "half_unit_pen"
+	WholeUnitPen DeliveryDevice = "whole_unit_pen"
+	Pump         DeliveryDevice = "pump"
+)
+
+// Smallest amount of insulin each DeliveryDevice can deliver
+var DeliveryIncrementMap = map[DeliveryDevice]float32{
+	HalfUnitPen:  0.5,
+	WholeUnitPen: 1,
+	Pump:         0.05,
+}
+
+type RoundingPolicy string
+
+const (
+	RoundDown    RoundingPolicy = "down"
+	RoundNearest RoundingPolicy = "nearest"
+	// Round down if Blood Sugar is trending down, otherwise round to the nearest increment
+	RoundDownWhenFalling RoundingPolicy = "down_when_falling"
+)
+
+type DeliveryInput struct {
+	// Device used to deliver the Bolus (no rounding if empty)
+	DeliveryDevice DeliveryDevice
+	// How to round to the DeliveryDevice's increment (defaults to RoundDown)
+	RoundingPolicy RoundingPolicy
+}
+
+// applyRounding sets the deliverable amounts of insulin in a Dose. If a safety limit reduced the
+// Bolus, or rounding up would go over one, it rounds down so the rounded Bolus stays within the limits.
+func applyRounding(dose *Dose, input DoseInput) {
+	increment := DeliveryIncrementMap[input.DeliveryInput.DeliveryDevice]
+
+	roundDown := true
+	switch input.DeliveryInput.RoundingPolicy {
+	case RoundNearest:
+		roundDown = false
+	case RoundDownWhenFalling:
+		roundDown = input.CorrectionInput.BloodGlucoseTrendInMgDlIn15Mins < 0
+	}
+	for _, warning := range dose.Warnings {
+		if warning.Code == MaxBolusExceeded || warning.Code == MaxInsulinOnBoardExceeded {
+			roundDown = true
+		}
+	}
+
+	dose.RoundedUnitsOfInsulin = roundToIncrement(dose.UnitsOfInsulin, increment, roundDown)
+	dose.RoundedExtendedUnitsOfInsulin = roundToIncrement(dose.ExtendedUnitsOfInsulin, increment, roundDown)
+	if !roundDown && roundedOverSafetyLimits(dose, input) {
+		dose.RoundedUnitsOfInsulin = roundToIncrement(dose.UnitsOfInsulin, increment, true)
+		dose.RoundedExtendedUnitsOfInsulin = roundToIncrement(dose.ExtendedUnitsOfInsulin, increment, true)
+	}
+}
+
+// roundedOverSafetyLimits reports whether the rounded Bolus goes over the Max Bolus or Max Insulin On Board
+func roundedOverSafetyLimits(dose *Dose, input DoseInput) bool {
+	// Rounded amounts are to the hundredth of a unit, allow for floating point error
+	const tolerance = 1e-4
+	rounded := dose.RoundedUnitsOfInsulin + dose.RoundedExtendedUnitsOfInsulin
+	if input.SafetyInput.MaxBolusUnitsOfInsulin > 0 && rounded > input.SafetyInput.MaxBolusUnitsOfInsulin+tolerance {
+		return true
+	}
+	insulinOnBoard := -dose.Breakdown.InsulinOnBoardFactor
+	return input.SafetyInput.MaxInsulinOnBoard > 0 && insulinOnBoard+rounded > input.SafetyInput.MaxInsulinOnBoard+tolerance
+}
+
+func roundToIncrement(unitsOfInsulin float32, increment float32, roundDown bool) float32 {
+	if unitsOfInsulin <= 0 {
+		return 0
+	}
+	if increment <= 0 {
+		return unitsOfInsulin
+	}
+
+	// Allow for floating point error, so that 1.5 / 0.05 is not rounded down to 29
+	increments := float64(unitsOfInsulin)/float64(increment) + 1e-6
+	if roundDown {
+		increments = math.Floor(increments)
+	} else {
+		increments = math.Round(increments)
+	}
+	return float32(math.Round(increments*float64(increment)*100) / 100)
+}
@@ -2,14 +2,22 @@ package bolus
 
 import (
 	"log"
+	"math"
 	"time"
 )
 
 type DoseInput struct {
+	// Time the dose is calculated for, used throughout the calculation (defaults to now)
+	Time time.Time
+	// Location used to evaluate time of day sensitive factors (defaults to Local)
+	Location *time.Location
 	FoodInput
 	CorrectionInput
 	InsulinOnBoardInput
+	CarbsOnBoardInput
 	ExerciseInput
+	SafetyInput
+	DeliveryInput
 }
 
 type FoodInput struct {
@@ -29,6 +37,10 @@ type FoodInput struct {
 	ProteinMultiplier float32
 	// For Carbs under the threshold, Protein will be counted
 	CarbThresholdToCountProteinUnder float32
+	// Grams of Fat
+	GramsOfFat float32
+	// 0 to ignore Fat-Protein Units (Warsaw method), 1 to count all of them as an extended Bolus
+	FatProteinUnitMultiplier float32
 	// Insulin to Carb Ratio at a given time of day
 	InsulinToCarbRatio TimeSensitiveFactor
 }
@@ -38,17 +50,30 @@ type CorrectionInput struct {
 	CurrentBloodGlucoseLevelInMgDl float32
 	// Blood Sugar Trend (Delta)
 	BloodGlucoseTrendInMgDlIn15Mins float32
-	// Target Blood Sugar
-	TargetBloodGlucoseLevelInMgDl float32
+	// How the Blood Sugar Trend was determined (for example "fitted" or "arrow"), reported in the Breakdown
+	BloodGlucoseTrendMethod string
+	// Set when the Current Blood Sugar can't be relied on (StaleBloodGlucose, BloodGlucoseUnavailable, or
+	// TrendNotComputable), in which case no correction is made and a Warning is returned. A Current Blood
+	// Sugar that is set (for example a stale one) is still checked against the Low Glucose Suspend Threshold.
+	NoCorrectionReason WarningCode
+	// Target Blood Sugar (or Range) at a given time of day
+	TargetBloodGlucoseLevelInMgDl TimeSensitiveTarget
 	// Insulin Sensitivity Factor at a given time of day
 	InsulinSensitivityFactor TimeSensitiveFactor // Changes over time
 }
 
 type InsulinOnBoardInput struct {
-	// Time of the last Bolus
-	LastBolusTime time.Time
-	// Units of Insulin for the last Bolus
-	LastBolusUnitsOfInsulin float32
+	// Previous Boluses (Boluses older than the Duration of Insulin Action are ignored)
+	Boluses []Bolus
+	// How Insulin On Board decays over time (defaults to LegacyInsulinModel)
+	InsulinModel InsulinModel
+}
+
+type Bolus struct {
+	// Time the Bolus was taken
+	Time time.Time `json:"time"`
+	// Units of Insulin for the Bolus
+	UnitsOfInsulin float32 `json:"units_of_insulin"`
 }
 
 type ExerciseInput struct {
@@ -77,6 +102,9 @@ const (
 	High   ExerciseIntensity = "high"
 )
 
+// Time between each multiplier in InsulinOnBoardMultiplierList
+const InsulinOnBoardMultiplierInterval = 30 * time.Minute
+
 var InsulinOnBoardMultiplierList = []float32{
 	1,    // 0 hours
 	0.9,  // 0.5 hours
@@ -107,10 +135,31 @@ var ExerciseMultiplierMap = []map[ExerciseIntensity]float32{
 	},
 }
 
+// Kilocalories in a Fat-Protein Unit, which is covered like 10 grams of Carbohydrates
+const KilocaloriesPerFatProteinUnit = 100
+
+// Minutes to extend the Fat-Protein Unit portion of a Bolus over, by number of Fat-Protein Units
+var FatProteinUnitDurationList = []float32{
+	180, // up to 1 FPU
+	240, // up to 2 FPU
+	300, // up to 3 FPU
+	480, // over 3 FPU
+}
+
 type Dose struct {
-	// Units of Insulin for the Bolus dose
+	// Units of Insulin for the upfront Bolus dose
 	UnitsOfInsulin float32
-	// If UnitsOfInsulin is negative, the grams of Carbohydrates to consume to get back to the Target Blood Glucose Level
+	// Units of Insulin for the extended (or delayed) portion of the Bolus, covering Fat and Protein
+	ExtendedUnitsOfInsulin float32
+	// Minutes to extend ExtendedUnitsOfInsulin over
+	ExtendedDurationInMinutes float32
+	// UnitsOfInsulin rounded to what the Delivery Device can deliver (0 if negative)
+	RoundedUnitsOfInsulin float32
+	// ExtendedUnitsOfInsulin rounded to what the Delivery Device can deliver
+	RoundedExtendedUnitsOfInsulin float32
+	// Set when a safety limit reduced or blocked the Bolus, or an input is missing
+	Warnings []Warning
+	// If UnitsOfInsulin is negative, the grams of Carbohydrates to consume to get back to the Target Blood Glucose Range
 	GramsOfCarbs float32
 	// A breakdown of the major factors contributing to the Bolus dose
 	Breakdown struct {
@@ -118,41 +167,133 @@ type Dose struct {
 		CorrectionFactor     float32
 		InsulinOnBoardFactor float32
 		ExerciseMultiplier   float32
+		FatProteinUnits      float32
+		FatProteinFactor     float32
+		// How the Blood Sugar Trend used for the CorrectionFactor was determined
+		BloodGlucoseTrendMethod string
+		// Grams of Carbohydrates counted for the FoodFactor
+		NetGramsOfCarbs float32
+		// Grams of Carbohydrates from previous Meals still being absorbed
+		CarbsOnBoard float32
+		// Offsets a positive CorrectionFactor, as a rise while Carbs are still being absorbed was
+		// already covered when the Meal was dosed for
+		CarbsOnBoardFactor float32
+		// The contribution of each previous Bolus to the InsulinOnBoardFactor
+		InsulinOnBoard []InsulinOnBoardContribution
 	}
 }
 
-func GetDose(input DoseInput) Dose {
-	dose := Dose{}
+type InsulinOnBoardContribution struct {
+	// Time the Bolus was taken
+	Time time.Time
+	// Units of Insulin for the Bolus
+	UnitsOfInsulin float32
+	// Portion of the InsulinOnBoardFactor due to the Bolus
+	InsulinOnBoardFactor float32
+}
+
+// GetDose calculates a Bolus dose. If the input is invalid, it returns ValidationErrors.
+func GetDose(input DoseInput) (Dose, error) {
+	now := localTime(input)
 
-	// Validate Required Params
-	if input.FoodInput.InsulinToCarbRatio == nil || input.CorrectionInput.TargetBloodGlucoseLevelInMgDl <= 55 || input.CorrectionInput.CurrentBloodGlucoseLevelInMgDl <= 0 || input.CorrectionInput.InsulinSensitivityFactor == nil {
-		panic("valid inputs required for 'insulin_to_carb_ratio', 'target_blood_glucose_level_in_mg_dl', 'current_blood_glucose_level_in_mg_dl', and 'insulin_sensitivity_factor'")
+	// Validate Params
+	if errs := validate(input, now); len(errs) > 0 {
+		return Dose{}, errs
 	}
 
+	dose := calculateDose(input, now)
+
+	log.Printf("DOSE at %s, Input: %+v, Output: %+v", now.String(), input, dose)
+
+	return dose, nil
+}
+
+// localTime returns the time the input is evaluated at
+func localTime(input DoseInput) time.Time {
+	now := input.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if input.Location != nil {
+		now = now.In(input.Location)
+	}
+	return now
+}
+
+// calculateDose calculates a Bolus dose for a validated input
+func calculateDose(input DoseInput, now time.Time) Dose {
+	dose := Dose{}
+
 	// Calculate Food Factor
 	grams := input.FoodInput.TotalGramsOfCarbs
 	grams -= input.FoodInput.GramsOfFiber * (1 - input.FoodInput.FiberMultiplier)
 	grams -= input.FoodInput.GramsOfSugarAlcohol * (1 - input.FoodInput.SugarAlcoholMultiplier)
+	gramsOfProteinNotCounted := input.FoodInput.GramsOfProtein
 	if grams < input.FoodInput.CarbThresholdToCountProteinUnder {
 		grams += input.FoodInput.GramsOfProtein * input.FoodInput.ProteinMultiplier
+		gramsOfProteinNotCounted = 0
 	}
-	insulinToCarbRatio := input.FoodInput.InsulinToCarbRatio.GetAtTime(time.Now())
-	if insulinToCarbRatio > 0 {
-		dose.Breakdown.FoodFactor = grams / input.FoodInput.InsulinToCarbRatio.GetAtTime(time.Now())
+	insulinToCarbRatio := input.FoodInput.InsulinToCarbRatio.GetAtTime(now)
+	dose.Breakdown.NetGramsOfCarbs = grams
+	dose.Breakdown.FoodFactor = grams / insulinToCarbRatio
+
+	// Calculate Fat-Protein Factor (Warsaw method), excluding Protein already counted above
+	if input.FoodInput.FatProteinUnitMultiplier > 0 {
+		kilocalories := input.FoodInput.GramsOfFat*9 + gramsOfProteinNotCounted*4
+		dose.Breakdown.FatProteinUnits = kilocalories / KilocaloriesPerFatProteinUnit
+		if dose.Breakdown.FatProteinUnits > 0 {
+			dose.Breakdown.FatProteinFactor = dose.Breakdown.FatProteinUnits * 10 * input.FoodInput.FatProteinUnitMultiplier / insulinToCarbRatio
+			durationIncrement := int(math.Ceil(float64(dose.Breakdown.FatProteinUnits))) - 1
+			if durationIncrement >= len(FatProteinUnitDurationList) {
+				durationIncrement = len(FatProteinUnitDurationList) - 1
+			}
+			dose.ExtendedDurationInMinutes = FatProteinUnitDurationList[durationIncrement]
+		}
 	}
 
-	// Calculate Correction Factor
+	// Calculate Correction Factor. Within the Target Range no correction is made, otherwise correct to the nearest bound.
 	bloodSugarIn15Mins := input.CorrectionInput.CurrentBloodGlucoseLevelInMgDl + input.CorrectionInput.BloodGlucoseTrendInMgDlIn15Mins
-	correction := bloodSugarIn15Mins - input.CorrectionInput.TargetBloodGlucoseLevelInMgDl
-	insulinSensitivityFactor := input.CorrectionInput.InsulinSensitivityFactor.GetAtTime(time.Now())
-	if insulinSensitivityFactor > 0 {
-		dose.Breakdown.CorrectionFactor = correction / input.CorrectionInput.InsulinSensitivityFactor.GetAtTime(time.Now())
+	target := input.CorrectionInput.TargetBloodGlucoseLevelInMgDl.GetAtTime(now)
+	var correction float32
+	// Unless the Current Blood Sugar can't be relied on (see applySafetyLimits for the Warning)
+	if input.CorrectionInput.NoCorrectionReason == "" {
+		if bloodSugarIn15Mins > target.High {
+			correction = bloodSugarIn15Mins - target.High
+		} else if bloodSugarIn15Mins < target.Low {
+			correction = bloodSugarIn15Mins - target.Low
+		}
+	}
+	insulinSensitivityFactor := input.CorrectionInput.InsulinSensitivityFactor.GetAtTime(now)
+	dose.Breakdown.CorrectionFactor = correction / insulinSensitivityFactor
+	dose.Breakdown.BloodGlucoseTrendMethod = input.CorrectionInput.BloodGlucoseTrendMethod
+
+	// Calculate Insulin On Board, summing what remains of each previous Bolus
+	insulinModel := input.InsulinOnBoardInput.InsulinModel
+	if insulinModel == nil {
+		insulinModel = LegacyInsulinModel
 	}
+	for _, bolus := range input.InsulinOnBoardInput.Boluses {
+		fractionOnBoard := insulinModel.FractionOnBoard(now.Sub(bolus.Time))
+		if fractionOnBoard == 0 {
+			continue
+		}
 
-	// Calculate Insulin On Board
-	incrementSinceLastBolus := int(time.Since(input.InsulinOnBoardInput.LastBolusTime).Minutes() / 30)
-	if incrementSinceLastBolus < len(InsulinOnBoardMultiplierList) {
-		dose.Breakdown.InsulinOnBoardFactor = input.InsulinOnBoardInput.LastBolusUnitsOfInsulin * -InsulinOnBoardMultiplierList[incrementSinceLastBolus]
+		factor := bolus.UnitsOfInsulin * -fractionOnBoard
+		dose.Breakdown.InsulinOnBoardFactor += factor
+		dose.Breakdown.InsulinOnBoard = append(dose.Breakdown.InsulinOnBoard, InsulinOnBoardContribution{
+			Time:                 bolus.Time,
+			UnitsOfInsulin:       bolus.UnitsOfInsulin,
+			InsulinOnBoardFactor: factor,
+		})
+	}
+
+	// Calculate Carbs On Board, which offsets (but never reverses) a positive correction
+	for _, meal := range input.CarbsOnBoardInput.Meals {
+		fractionAbsorbed := input.CarbsOnBoardInput.CarbAbsorptionModel.FractionAbsorbed(now.Sub(meal.Time), meal.AbsorptionTime())
+		dose.Breakdown.CarbsOnBoard += meal.GramsOfCarbs * (1 - fractionAbsorbed)
+	}
+	if dose.Breakdown.CorrectionFactor > 0 && dose.Breakdown.CarbsOnBoard > 0 {
+		dose.Breakdown.CarbsOnBoardFactor = -min(dose.Breakdown.CorrectionFactor, dose.Breakdown.CarbsOnBoard/insulinToCarbRatio)
 	}
 
 	// Calculate Exercise Multiplier
@@ -167,12 +308,15 @@ func GetDose(input DoseInput) Dose {
 	}
 
 	// Calculate Total. If negative, calculate the grams of carbs required to bring back to target.
-	dose.UnitsOfInsulin = (dose.Breakdown.FoodFactor + dose.Breakdown.CorrectionFactor + dose.Breakdown.InsulinOnBoardFactor) * dose.Breakdown.ExerciseMultiplier
-	if dose.UnitsOfInsulin < 0 && insulinToCarbRatio > 0 {
+	dose.UnitsOfInsulin = (dose.Breakdown.FoodFactor + dose.Breakdown.CorrectionFactor + dose.Breakdown.CarbsOnBoardFactor + dose.Breakdown.InsulinOnBoardFactor) * dose.Breakdown.ExerciseMultiplier
+	dose.ExtendedUnitsOfInsulin = dose.Breakdown.FatProteinFactor * dose.Breakdown.ExerciseMultiplier
+	if dose.UnitsOfInsulin < 0 {
 		dose.GramsOfCarbs = -dose.UnitsOfInsulin * insulinToCarbRatio
 	}
 
-	log.Printf("DOSE at %s, Input: %+v, Output: %+v", time.Now().String(), input, dose)
+	// Apply Safety Limits, then round to what can be delivered
+	applySafetyLimits(&dose, input)
+	applyRounding(&dose, input)
 
 	return dose
 }
@@ -1,12 +1,14 @@
 package bolus
 
 import (
+	"errors"
+	"slices"
 	"testing"
 	"time"
 )
 
 func TestDoseFoodFactor(t *testing.T) {
-	dose := GetDose(DoseInput{
+	dose, err := GetDose(DoseInput{
 		FoodInput: FoodInput{
 			TotalGramsOfCarbs:                20,
 			GramsOfFiber:                     8,
@@ -20,10 +22,13 @@ func TestDoseFoodFactor(t *testing.T) {
 		},
 		CorrectionInput: CorrectionInput{
 			CurrentBloodGlucoseLevelInMgDl: 100,
-			TargetBloodGlucoseLevelInMgDl:  100,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
 			InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if dose.UnitsOfInsulin != 4 {
 		t.Errorf("expected 4, got %f", dose.UnitsOfInsulin)
 	}
@@ -44,18 +49,58 @@ func TestDoseFoodFactor(t *testing.T) {
 	}
 }
 
+func TestDoseFatProteinFactor(t *testing.T) {
+	dose, err := GetDose(DoseInput{
+		FoodInput: FoodInput{
+			TotalGramsOfCarbs:                50,
+			GramsOfProtein:                   5,
+			ProteinMultiplier:                0.5,
+			CarbThresholdToCountProteinUnder: 21,
+			GramsOfFat:                       20,
+			FatProteinUnitMultiplier:         1,
+			InsulinToCarbRatio:               SimpleTimeSensitiveFactor(10),
+		},
+		CorrectionInput: CorrectionInput{
+			CurrentBloodGlucoseLevelInMgDl: 100,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+			InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dose.UnitsOfInsulin != 5 {
+		t.Errorf("expected 5, got %f", dose.UnitsOfInsulin)
+	}
+	if dose.ExtendedUnitsOfInsulin != 2 {
+		t.Errorf("expected 2, got %f", dose.ExtendedUnitsOfInsulin)
+	}
+	if dose.ExtendedDurationInMinutes != 240 {
+		t.Errorf("expected 240, got %f", dose.ExtendedDurationInMinutes)
+	}
+	if dose.Breakdown.FatProteinUnits != 2 {
+		t.Errorf("expected 2, got %f", dose.Breakdown.FatProteinUnits)
+	}
+	if dose.Breakdown.FatProteinFactor != 2 {
+		t.Errorf("expected 2, got %f", dose.Breakdown.FatProteinFactor)
+	}
+}
+
 func TestDoseCorrectionFactor(t *testing.T) {
-	dose := GetDose(DoseInput{
+	dose, err := GetDose(DoseInput{
 		FoodInput: FoodInput{
 			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
 		},
 		CorrectionInput: CorrectionInput{
 			CurrentBloodGlucoseLevelInMgDl:  120,
 			BloodGlucoseTrendInMgDlIn15Mins: 15,
-			TargetBloodGlucoseLevelInMgDl:   90,
+			TargetBloodGlucoseLevelInMgDl:   SimpleTimeSensitiveTarget(90),
 			InsulinSensitivityFactor:        SimpleTimeSensitiveFactor(30),
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if dose.UnitsOfInsulin != 1.5 {
 		t.Errorf("expected 1.5, got %f", dose.UnitsOfInsulin)
 	}
@@ -77,20 +122,24 @@ func TestDoseCorrectionFactor(t *testing.T) {
 }
 
 func TestDoseInsulinOnBoardFactor(t *testing.T) {
-	dose := GetDose(DoseInput{
+	dose, err := GetDose(DoseInput{
 		FoodInput: FoodInput{
 			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
 		},
 		CorrectionInput: CorrectionInput{
 			CurrentBloodGlucoseLevelInMgDl: 190,
-			TargetBloodGlucoseLevelInMgDl:  100,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
 			InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
 		},
 		InsulinOnBoardInput: InsulinOnBoardInput{
-			LastBolusTime:           time.Now().Add(-100 * time.Minute),
-			LastBolusUnitsOfInsulin: 2,
+			Boluses: []Bolus{
+				{Time: time.Now().Add(-100 * time.Minute), UnitsOfInsulin: 2},
+			},
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if dose.UnitsOfInsulin != 2 {
 		t.Errorf("expected 2, got %f", dose.UnitsOfInsulin)
 	}
@@ -111,14 +160,123 @@ func TestDoseInsulinOnBoardFactor(t *testing.T) {
 	}
 }
 
+func TestDoseInsulinOnBoardFactorStacked(t *testing.T) {
+	dose, err := GetDose(DoseInput{
+		FoodInput: FoodInput{
+			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
+		},
+		CorrectionInput: CorrectionInput{
+			CurrentBloodGlucoseLevelInMgDl: 190,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+			InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+		},
+		InsulinOnBoardInput: InsulinOnBoardInput{
+			Boluses: []Bolus{
+				{Time: time.Now().Add(-5 * time.Hour), UnitsOfInsulin: 6},
+				{Time: time.Now().Add(-100 * time.Minute), UnitsOfInsulin: 2},
+				{Time: time.Now().Add(-10 * time.Minute), UnitsOfInsulin: 1},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dose.UnitsOfInsulin != 1 {
+		t.Errorf("expected 1, got %f", dose.UnitsOfInsulin)
+	}
+	if dose.Breakdown.InsulinOnBoardFactor != -2 {
+		t.Errorf("expected -2, got %f", dose.Breakdown.InsulinOnBoardFactor)
+	}
+	if len(dose.Breakdown.InsulinOnBoard) != 2 {
+		t.Fatalf("expected 2 contributing boluses, got %d", len(dose.Breakdown.InsulinOnBoard))
+	}
+	if dose.Breakdown.InsulinOnBoard[0].InsulinOnBoardFactor != -1 {
+		t.Errorf("expected -1, got %f", dose.Breakdown.InsulinOnBoard[0].InsulinOnBoardFactor)
+	}
+	if dose.Breakdown.InsulinOnBoard[1].InsulinOnBoardFactor != -1 {
+		t.Errorf("expected -1, got %f", dose.Breakdown.InsulinOnBoard[1].InsulinOnBoardFactor)
+	}
+}
+
+func TestDoseCarbsOnBoardFactor(t *testing.T) {
+	now := time.Date(2025, 4, 7, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name                       string
+		currentBloodGlucose        float32
+		meals                      []Meal
+		expectedCarbsOnBoard       float32
+		expectedCarbsOnBoardFactor float32
+		expectedUnitsOfInsulin     float32
+	}{
+		{
+			name:                       "offsets correction",
+			currentBloodGlucose:        190,
+			meals:                      []Meal{{Time: now.Add(-90 * time.Minute), GramsOfCarbs: 30, AbsorptionTimeInMinutes: 180}},
+			expectedCarbsOnBoard:       15,
+			expectedCarbsOnBoardFactor: -1.5,
+			expectedUnitsOfInsulin:     1.5,
+		},
+		{
+			name:                       "never more than correction",
+			currentBloodGlucose:        190,
+			meals:                      []Meal{{Time: now.Add(-90 * time.Minute), GramsOfCarbs: 80}},
+			expectedCarbsOnBoard:       40,
+			expectedCarbsOnBoardFactor: -3,
+			expectedUnitsOfInsulin:     0,
+		},
+		{
+			name:                   "absorbed",
+			currentBloodGlucose:    190,
+			meals:                  []Meal{{Time: now.Add(-3 * time.Hour), GramsOfCarbs: 60}},
+			expectedUnitsOfInsulin: 3,
+		},
+		{
+			name:                   "no correction",
+			currentBloodGlucose:    100,
+			meals:                  []Meal{{Time: now.Add(-90 * time.Minute), GramsOfCarbs: 80}},
+			expectedCarbsOnBoard:   40,
+			expectedUnitsOfInsulin: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dose, err := GetDose(DoseInput{
+				Time: now,
+				FoodInput: FoodInput{
+					InsulinToCarbRatio: SimpleTimeSensitiveFactor(10),
+				},
+				CorrectionInput: CorrectionInput{
+					CurrentBloodGlucoseLevelInMgDl: tc.currentBloodGlucose,
+					TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+					InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+				},
+				CarbsOnBoardInput: CarbsOnBoardInput{
+					Meals: tc.meals,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dose.Breakdown.CarbsOnBoard != tc.expectedCarbsOnBoard {
+				t.Errorf("expected %f, got %f", tc.expectedCarbsOnBoard, dose.Breakdown.CarbsOnBoard)
+			}
+			if dose.Breakdown.CarbsOnBoardFactor != tc.expectedCarbsOnBoardFactor {
+				t.Errorf("expected %f, got %f", tc.expectedCarbsOnBoardFactor, dose.Breakdown.CarbsOnBoardFactor)
+			}
+			if dose.UnitsOfInsulin != tc.expectedUnitsOfInsulin {
+				t.Errorf("expected %f, got %f", tc.expectedUnitsOfInsulin, dose.UnitsOfInsulin)
+			}
+		})
+	}
+}
+
 func TestExerciseMultiplier(t *testing.T) {
-	dose := GetDose(DoseInput{
+	dose, err := GetDose(DoseInput{
 		FoodInput: FoodInput{
 			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
 		},
 		CorrectionInput: CorrectionInput{
 			CurrentBloodGlucoseLevelInMgDl: 160,
-			TargetBloodGlucoseLevelInMgDl:  100,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
 			InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
 		},
 		ExerciseInput: ExerciseInput{
@@ -126,6 +284,9 @@ func TestExerciseMultiplier(t *testing.T) {
 			ExerciseIntensity: High,
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if dose.UnitsOfInsulin != 1 {
 		t.Errorf("expected 1, got %f", dose.UnitsOfInsulin)
 	}
@@ -147,17 +308,20 @@ func TestExerciseMultiplier(t *testing.T) {
 }
 
 func TestGramsOfCarbsDueToLow(t *testing.T) {
-	dose := GetDose(DoseInput{
+	dose, err := GetDose(DoseInput{
 		FoodInput: FoodInput{
 			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
 		},
 		CorrectionInput: CorrectionInput{
 			CurrentBloodGlucoseLevelInMgDl:  70,
 			BloodGlucoseTrendInMgDlIn15Mins: -15,
-			TargetBloodGlucoseLevelInMgDl:   100,
+			TargetBloodGlucoseLevelInMgDl:   SimpleTimeSensitiveTarget(100),
 			InsulinSensitivityFactor:        SimpleTimeSensitiveFactor(15),
 		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if dose.UnitsOfInsulin != -3 {
 		t.Errorf("expected -3, got %f", dose.UnitsOfInsulin)
 	}
@@ -177,3 +341,444 @@ func TestGramsOfCarbsDueToLow(t *testing.T) {
 		t.Errorf("expected 1, got %f", dose.Breakdown.ExerciseMultiplier)
 	}
 }
+
+func TestDoseTargetRange(t *testing.T) {
+	for _, test := range []struct {
+		currentBloodGlucose float32
+		expected            float32
+	}{
+		{currentBloodGlucose: 110, expected: 0},
+		{currentBloodGlucose: 180, expected: 2},
+		{currentBloodGlucose: 70, expected: -1},
+	} {
+		dose, err := GetDose(DoseInput{
+			FoodInput: FoodInput{
+				InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
+			},
+			CorrectionInput: CorrectionInput{
+				CurrentBloodGlucoseLevelInMgDl: test.currentBloodGlucose,
+				TargetBloodGlucoseLevelInMgDl:  TargetRange{Low: 100, High: 120},
+				InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dose.Breakdown.CorrectionFactor != test.expected {
+			t.Errorf("expected %f at %f, got %f", test.expected, test.currentBloodGlucose, dose.Breakdown.CorrectionFactor)
+		}
+	}
+}
+
+func TestDoseNoCorrection(t *testing.T) {
+	for _, test := range []struct {
+		reason                 WarningCode
+		currentBloodGlucose    float32
+		expectedUnitsOfInsulin float32
+		expectedWarnings       []WarningCode
+	}{
+		{reason: StaleBloodGlucose, currentBloodGlucose: 150, expectedUnitsOfInsulin: 4, expectedWarnings: []WarningCode{StaleBloodGlucose}},
+		{reason: TrendNotComputable, currentBloodGlucose: 150, expectedUnitsOfInsulin: 4, expectedWarnings: []WarningCode{TrendNotComputable}},
+		{reason: BloodGlucoseUnavailable, expectedUnitsOfInsulin: 4, expectedWarnings: []WarningCode{BloodGlucoseUnavailable}},
+		// A low reading is suspended for, even if it can't be corrected with
+		{reason: StaleBloodGlucose, currentBloodGlucose: 60, expectedUnitsOfInsulin: 0, expectedWarnings: []WarningCode{StaleBloodGlucose, LowGlucoseSuspended}},
+		{reason: TrendNotComputable, currentBloodGlucose: 60, expectedUnitsOfInsulin: 0, expectedWarnings: []WarningCode{TrendNotComputable, LowGlucoseSuspended}},
+	} {
+		dose, err := GetDose(DoseInput{
+			FoodInput: FoodInput{
+				TotalGramsOfCarbs:  20,
+				InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
+			},
+			CorrectionInput: CorrectionInput{
+				CurrentBloodGlucoseLevelInMgDl: test.currentBloodGlucose,
+				TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+				InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+				NoCorrectionReason:             test.reason,
+			},
+			SafetyInput: SafetyInput{
+				LowGlucoseSuspendThresholdInMgDl: 70,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dose.UnitsOfInsulin != test.expectedUnitsOfInsulin {
+			t.Errorf("%s at %f: expected %f, got %f", test.reason, test.currentBloodGlucose, test.expectedUnitsOfInsulin, dose.UnitsOfInsulin)
+		}
+		if dose.Breakdown.CorrectionFactor != 0 {
+			t.Errorf("%s at %f: expected 0, got %f", test.reason, test.currentBloodGlucose, dose.Breakdown.CorrectionFactor)
+		}
+		var warnings []WarningCode
+		for _, warning := range dose.Warnings {
+			warnings = append(warnings, warning.Code)
+		}
+		if !slices.Equal(warnings, test.expectedWarnings) {
+			t.Errorf("%s at %f: expected %v warnings, got %v", test.reason, test.currentBloodGlucose, test.expectedWarnings, warnings)
+		}
+	}
+
+	// Without a Current Blood Sugar
+	_, err := GetDose(DoseInput{
+		FoodInput: FoodInput{
+			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
+		},
+		CorrectionInput: CorrectionInput{
+			TargetBloodGlucoseLevelInMgDl: SimpleTimeSensitiveTarget(100),
+			InsulinSensitivityFactor:      SimpleTimeSensitiveFactor(30),
+			NoCorrectionReason:            BloodGlucoseUnavailable,
+		},
+	})
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestDoseSafetyLimits(t *testing.T) {
+	for _, test := range []struct {
+		name                           string
+		currentBloodGlucose            float32
+		trend                          float32
+		totalGramsOfCarbs              float32
+		gramsOfFat                     float32
+		boluses                        []Bolus
+		safety                         SafetyInput
+		expectedUnitsOfInsulin         float32
+		expectedExtendedUnitsOfInsulin float32
+		expectedWarnings               []WarningCode
+	}{
+		{
+			name:                   "no limits",
+			currentBloodGlucose:    100,
+			totalGramsOfCarbs:      500,
+			expectedUnitsOfInsulin: 100,
+		},
+		{
+			name:                   "under limits",
+			currentBloodGlucose:    100,
+			totalGramsOfCarbs:      20,
+			safety:                 SafetyInput{MaxBolusUnitsOfInsulin: 10, MaxInsulinOnBoard: 15, LowGlucoseSuspendThresholdInMgDl: 70},
+			expectedUnitsOfInsulin: 4,
+		},
+		{
+			name:                   "max bolus",
+			currentBloodGlucose:    100,
+			totalGramsOfCarbs:      500,
+			safety:                 SafetyInput{MaxBolusUnitsOfInsulin: 10},
+			expectedUnitsOfInsulin: 10,
+			expectedWarnings:       []WarningCode{MaxBolusExceeded},
+		},
+		{
+			name:                           "max bolus reduces extended first",
+			currentBloodGlucose:            100,
+			totalGramsOfCarbs:              40,
+			gramsOfFat:                     50,
+			safety:                         SafetyInput{MaxBolusUnitsOfInsulin: 10},
+			expectedUnitsOfInsulin:         8,
+			expectedExtendedUnitsOfInsulin: 2,
+			expectedWarnings:               []WarningCode{MaxBolusExceeded},
+		},
+		{
+			name:                   "max insulin on board",
+			currentBloodGlucose:    100,
+			totalGramsOfCarbs:      50,
+			boluses:                []Bolus{{Time: time.Now(), UnitsOfInsulin: 6}},
+			safety:                 SafetyInput{MaxInsulinOnBoard: 8},
+			expectedUnitsOfInsulin: 2,
+			expectedWarnings:       []WarningCode{MaxInsulinOnBoardExceeded},
+		},
+		{
+			name:                   "max insulin on board already exceeded",
+			currentBloodGlucose:    100,
+			totalGramsOfCarbs:      100,
+			boluses:                []Bolus{{Time: time.Now(), UnitsOfInsulin: 12}},
+			safety:                 SafetyInput{MaxInsulinOnBoard: 10},
+			expectedUnitsOfInsulin: 0,
+			expectedWarnings:       []WarningCode{MaxInsulinOnBoardExceeded},
+		},
+		{
+			name:                   "max insulin on board and max bolus",
+			currentBloodGlucose:    100,
+			totalGramsOfCarbs:      500,
+			boluses:                []Bolus{{Time: time.Now(), UnitsOfInsulin: 5}},
+			safety:                 SafetyInput{MaxBolusUnitsOfInsulin: 10, MaxInsulinOnBoard: 20},
+			expectedUnitsOfInsulin: 10,
+			expectedWarnings:       []WarningCode{MaxInsulinOnBoardExceeded, MaxBolusExceeded},
+		},
+		{
+			name:                   "low glucose suspend",
+			currentBloodGlucose:    65,
+			totalGramsOfCarbs:      50,
+			safety:                 SafetyInput{LowGlucoseSuspendThresholdInMgDl: 70},
+			expectedUnitsOfInsulin: 0,
+			expectedWarnings:       []WarningCode{LowGlucoseSuspended},
+		},
+		{
+			name:                   "low glucose suspend when falling",
+			currentBloodGlucose:    80,
+			trend:                  -15,
+			totalGramsOfCarbs:      50,
+			safety:                 SafetyInput{LowGlucoseSuspendThresholdInMgDl: 70},
+			expectedUnitsOfInsulin: 0,
+			expectedWarnings:       []WarningCode{LowGlucoseSuspended},
+		},
+		{
+			name:                   "low glucose suspend without insulin",
+			currentBloodGlucose:    60,
+			safety:                 SafetyInput{LowGlucoseSuspendThresholdInMgDl: 70},
+			expectedUnitsOfInsulin: -4,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dose, err := GetDose(DoseInput{
+				FoodInput: FoodInput{
+					TotalGramsOfCarbs:        test.totalGramsOfCarbs,
+					GramsOfFat:               test.gramsOfFat,
+					FatProteinUnitMultiplier: 1,
+					InsulinToCarbRatio:       SimpleTimeSensitiveFactor(5),
+				},
+				CorrectionInput: CorrectionInput{
+					CurrentBloodGlucoseLevelInMgDl:  test.currentBloodGlucose,
+					BloodGlucoseTrendInMgDlIn15Mins: test.trend,
+					TargetBloodGlucoseLevelInMgDl:   SimpleTimeSensitiveTarget(100),
+					InsulinSensitivityFactor:        SimpleTimeSensitiveFactor(10),
+				},
+				InsulinOnBoardInput: InsulinOnBoardInput{
+					Boluses: test.boluses,
+				},
+				SafetyInput: test.safety,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dose.UnitsOfInsulin != test.expectedUnitsOfInsulin {
+				t.Errorf("expected %f, got %f", test.expectedUnitsOfInsulin, dose.UnitsOfInsulin)
+			}
+			if dose.ExtendedUnitsOfInsulin != test.expectedExtendedUnitsOfInsulin {
+				t.Errorf("expected %f extended, got %f", test.expectedExtendedUnitsOfInsulin, dose.ExtendedUnitsOfInsulin)
+			}
+			if len(dose.Warnings) != len(test.expectedWarnings) {
+				t.Fatalf("expected warnings %v, got %+v", test.expectedWarnings, dose.Warnings)
+			}
+			for i, warning := range dose.Warnings {
+				if warning.Code != test.expectedWarnings[i] {
+					t.Errorf("expected warning %s, got %s", test.expectedWarnings[i], warning.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestDoseRounding(t *testing.T) {
+	for _, test := range []struct {
+		name                          string
+		totalGramsOfCarbs             float32
+		trend                         float32
+		delivery                      DeliveryInput
+		safety                        SafetyInput
+		expectedRoundedUnitsOfInsulin float32
+		expectedUnitsOfInsulin        float32
+	}{
+		{
+			name:                          "no device",
+			totalGramsOfCarbs:             52,
+			expectedRoundedUnitsOfInsulin: 3.4666667,
+			expectedUnitsOfInsulin:        3.4666667,
+		},
+		{
+			name:                          "half unit pen rounds down by default",
+			totalGramsOfCarbs:             52,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen},
+			expectedRoundedUnitsOfInsulin: 3,
+			expectedUnitsOfInsulin:        3.4666667,
+		},
+		{
+			name:                          "half unit pen nearest",
+			totalGramsOfCarbs:             52,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen, RoundingPolicy: RoundNearest},
+			expectedRoundedUnitsOfInsulin: 3.5,
+			expectedUnitsOfInsulin:        3.4666667,
+		},
+		{
+			name:                          "whole unit pen nearest",
+			totalGramsOfCarbs:             52,
+			delivery:                      DeliveryInput{DeliveryDevice: WholeUnitPen, RoundingPolicy: RoundNearest},
+			expectedRoundedUnitsOfInsulin: 3,
+			expectedUnitsOfInsulin:        3.4666667,
+		},
+		{
+			name:                          "pump",
+			totalGramsOfCarbs:             45,
+			delivery:                      DeliveryInput{DeliveryDevice: Pump},
+			expectedRoundedUnitsOfInsulin: 3,
+			expectedUnitsOfInsulin:        3,
+		},
+		{
+			name:                          "down when falling and rising",
+			totalGramsOfCarbs:             52,
+			trend:                         15,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen, RoundingPolicy: RoundDownWhenFalling},
+			expectedRoundedUnitsOfInsulin: 4,
+			expectedUnitsOfInsulin:        3.9666667,
+		},
+		{
+			name:                          "down when falling and falling",
+			totalGramsOfCarbs:             60,
+			trend:                         -15,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen, RoundingPolicy: RoundDownWhenFalling},
+			expectedRoundedUnitsOfInsulin: 3.5,
+			expectedUnitsOfInsulin:        3.5,
+		},
+		{
+			name:                          "nearest stays within max bolus",
+			totalGramsOfCarbs:             60,
+			delivery:                      DeliveryInput{DeliveryDevice: WholeUnitPen, RoundingPolicy: RoundNearest},
+			safety:                        SafetyInput{MaxBolusUnitsOfInsulin: 3.7},
+			expectedRoundedUnitsOfInsulin: 3,
+			expectedUnitsOfInsulin:        3.7,
+		},
+		{
+			name:                          "nearest does not round up over max bolus",
+			totalGramsOfCarbs:             66,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen, RoundingPolicy: RoundNearest},
+			safety:                        SafetyInput{MaxBolusUnitsOfInsulin: 4.45},
+			expectedRoundedUnitsOfInsulin: 4,
+			expectedUnitsOfInsulin:        4.4,
+		},
+		{
+			name:                          "nearest does not round up over max insulin on board",
+			totalGramsOfCarbs:             66,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen, RoundingPolicy: RoundNearest},
+			safety:                        SafetyInput{MaxInsulinOnBoard: 4.45},
+			expectedRoundedUnitsOfInsulin: 4,
+			expectedUnitsOfInsulin:        4.4,
+		},
+		{
+			name:                          "negative",
+			trend:                         -30,
+			delivery:                      DeliveryInput{DeliveryDevice: HalfUnitPen},
+			expectedRoundedUnitsOfInsulin: 0,
+			expectedUnitsOfInsulin:        -1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dose, err := GetDose(DoseInput{
+				FoodInput: FoodInput{
+					TotalGramsOfCarbs:  test.totalGramsOfCarbs,
+					InsulinToCarbRatio: SimpleTimeSensitiveFactor(15),
+				},
+				CorrectionInput: CorrectionInput{
+					CurrentBloodGlucoseLevelInMgDl:  100,
+					BloodGlucoseTrendInMgDlIn15Mins: test.trend,
+					TargetBloodGlucoseLevelInMgDl:   SimpleTimeSensitiveTarget(100),
+					InsulinSensitivityFactor:        SimpleTimeSensitiveFactor(30),
+				},
+				SafetyInput:   test.safety,
+				DeliveryInput: test.delivery,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dose.UnitsOfInsulin != test.expectedUnitsOfInsulin {
+				t.Errorf("expected %f, got %f", test.expectedUnitsOfInsulin, dose.UnitsOfInsulin)
+			}
+			if dose.RoundedUnitsOfInsulin != test.expectedRoundedUnitsOfInsulin {
+				t.Errorf("expected %f rounded, got %f", test.expectedRoundedUnitsOfInsulin, dose.RoundedUnitsOfInsulin)
+			}
+		})
+	}
+}
+
+func TestDoseValidation(t *testing.T) {
+	_, err := GetDose(DoseInput{
+		FoodInput: FoodInput{
+			TotalGramsOfCarbs:  -20,
+			GramsOfFat:         -1,
+			InsulinToCarbRatio: SimpleTimeSensitiveFactor(0),
+		},
+		CorrectionInput: CorrectionInput{
+			CurrentBloodGlucoseLevelInMgDl: 100,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(50),
+		},
+		ExerciseInput: ExerciseInput{
+			MinutesOfExercise: 30,
+			ExerciseIntensity: "extreme",
+		},
+	})
+
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	expectedFields := []string{
+		"insulin_to_carb_ratio",
+		"insulin_sensitivity_factor",
+		"target_blood_glucose",
+		"total_grams_of_carbs",
+		"grams_of_fat",
+		"exercise_intensity",
+	}
+	if len(validationErrors) != len(expectedFields) {
+		t.Fatalf("expected %d errors, got %v", len(expectedFields), validationErrors)
+	}
+	for i, validationError := range validationErrors {
+		if validationError.Field != expectedFields[i] {
+			t.Errorf("expected %s, got %s", expectedFields[i], validationError.Field)
+		}
+	}
+}
+
+func TestDoseAtTime(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := ScheduledTimeSensitiveFactor{
+		{StartMinute: 6 * 60, Value: 8},
+		{StartMinute: 11 * 60, Value: 12},
+	}
+
+	for _, test := range []struct {
+		time                   time.Time
+		expectedFoodFactor     float32
+		expectedInsulinOnBoard float32
+	}{
+		{
+			time:                   time.Date(2025, 4, 7, 14, 59, 59, 0, time.UTC), // 10:59:59 in New York
+			expectedFoodFactor:     3,
+			expectedInsulinOnBoard: -0.9,
+		},
+		{
+			time:                   time.Date(2025, 4, 7, 15, 0, 0, 0, time.UTC), // 11:00 in New York
+			expectedFoodFactor:     2,
+			expectedInsulinOnBoard: -0.7,
+		},
+	} {
+		dose, err := GetDose(DoseInput{
+			Time:     test.time,
+			Location: location,
+			FoodInput: FoodInput{
+				TotalGramsOfCarbs:  24,
+				InsulinToCarbRatio: schedule,
+			},
+			CorrectionInput: CorrectionInput{
+				CurrentBloodGlucoseLevelInMgDl: 100,
+				TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+				InsulinSensitivityFactor:       schedule,
+			},
+			InsulinOnBoardInput: InsulinOnBoardInput{
+				Boluses: []Bolus{
+					{Time: time.Date(2025, 4, 7, 14, 0, 0, 0, time.UTC), UnitsOfInsulin: 1},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dose.Breakdown.FoodFactor != test.expectedFoodFactor {
+			t.Errorf("expected %f at %s, got %f", test.expectedFoodFactor, test.time, dose.Breakdown.FoodFactor)
+		}
+		if dose.Breakdown.InsulinOnBoardFactor != test.expectedInsulinOnBoard {
+			t.Errorf("expected %f at %s, got %f", test.expectedInsulinOnBoard, test.time, dose.Breakdown.InsulinOnBoardFactor)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package bolus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// An InsulinModel describes how much of a Bolus is still active as time passes
+type InsulinModel interface {
+	// Fraction of a Bolus still on board after the given time has elapsed, from 1 down to 0
+	FractionOnBoard(elapsed time.Duration) float32
+	// Duration of Insulin Action, after which none of a Bolus is on board
+	Duration() time.Duration
+}
+
+// An InsulinModel that steps through a table of multipliers at a fixed interval
+type TableInsulinModel struct {
+	// Fraction of a Bolus still on board at each interval, ending with 0
+	Multipliers []float32
+	// Time between each multiplier
+	Interval time.Duration
+}
+
+func (m TableInsulinModel) FractionOnBoard(elapsed time.Duration) float32 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	increment := int(elapsed / m.Interval)
+	if increment >= len(m.Multipliers) {
+		return 0
+	}
+	return m.Multipliers[increment]
+}
+
+func (m TableInsulinModel) Duration() time.Duration {
+	return time.Duration(len(m.Multipliers)-1) * m.Interval
+}
+
+// The exponential insulin activity curve used by Loop and oref, see
+// https://github.com/LoopKit/Loop/issues/388#issuecomment-317938473
+type ExponentialInsulinModel struct {
+	// Duration of Insulin Action
+	ActionDuration time.Duration
+	// Time after a Bolus at which insulin activity peaks
+	PeakTime time.Duration
+}
+
+func (m ExponentialInsulinModel) FractionOnBoard(elapsed time.Duration) float32 {
+	if elapsed <= 0 {
+		return 1
+	}
+	if elapsed >= m.ActionDuration {
+		return 0
+	}
+
+	t := elapsed.Minutes()
+	td := m.ActionDuration.Minutes()
+	tp := m.PeakTime.Minutes()
+
+	tau := tp * (1 - tp/td) / (1 - 2*tp/td)
+	a := 2 * tau / td
+	s := 1 / (1 - a + (1+a)*math.Exp(-td/tau))
+
+	return float32(1 - s*(1-a)*((t*t/(tau*td*(1-a))-t/tau-1)*math.Exp(-t/tau)+1))
+}
+
+func (m ExponentialInsulinModel) Duration() time.Duration {
+	return m.ActionDuration
+}
+
+// Validate checks that the curve can be computed, which requires the peak to be before half of
+// the Duration of Insulin Action
+func (m ExponentialInsulinModel) Validate() error {
+	if m.PeakTime <= 0 || m.ActionDuration <= 0 {
+		return errors.New("insulin peak time and duration of insulin action must be positive")
+	}
+	if m.PeakTime*2 >= m.ActionDuration {
+		return fmt.Errorf("insulin peak time (%s) must be less than half of the duration of insulin action (%s)", m.PeakTime, m.ActionDuration)
+	}
+	return nil
+}
+
+type InsulinType string
+
+const (
+	// The original 4 hour table of InsulinOnBoardMultiplierList
+	LegacyInsulin InsulinType = "legacy"
+	// Rapid-acting insulin like Humalog or Novolog
+	RapidActingInsulin InsulinType = "rapid_acting"
+	// Ultra rapid-acting insulin like Fiasp or Lyumjev
+	UltraRapidActingInsulin InsulinType = "ultra_rapid_acting"
+)
+
+var LegacyInsulinModel = TableInsulinModel{
+	Multipliers: InsulinOnBoardMultiplierList,
+	Interval:    InsulinOnBoardMultiplierInterval,
+}
+
+var RapidActingInsulinModel = ExponentialInsulinModel{
+	ActionDuration: 6 * time.Hour,
+	PeakTime:       75 * time.Minute,
+}
+
+var UltraRapidActingInsulinModel = ExponentialInsulinModel{
+	ActionDuration: 6 * time.Hour,
+	PeakTime:       55 * time.Minute,
+}
+
+// NewInsulinModel returns the InsulinModel for an InsulinType (LegacyInsulin if empty). For
+// exponential models, a non-zero actionDuration or peakTime overrides the preset.
+func NewInsulinModel(insulinType InsulinType, actionDuration time.Duration, peakTime time.Duration) (InsulinModel, error) {
+	var model ExponentialInsulinModel
+	switch insulinType {
+	case "", LegacyInsulin:
+		if actionDuration != 0 || peakTime != 0 {
+			return nil, errors.New("duration of insulin action and insulin peak time can not be changed for legacy insulin")
+		}
+		return LegacyInsulinModel, nil
+	case RapidActingInsulin:
+		model = RapidActingInsulinModel
+	case UltraRapidActingInsulin:
+		model = UltraRapidActingInsulinModel
+	default:
+		return nil, fmt.Errorf("unknown insulin type %q", insulinType)
+	}
+
+	if actionDuration != 0 {
+		model.ActionDuration = actionDuration
+	}
+	if peakTime != 0 {
+		model.PeakTime = peakTime
+	}
+	if err := model.Validate(); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
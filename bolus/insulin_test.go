@@ -0,0 +1,90 @@
+package bolus
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExponentialInsulinModel(t *testing.T) {
+	if RapidActingInsulinModel.FractionOnBoard(0) != 1 {
+		t.Errorf("expected 1, got %f", RapidActingInsulinModel.FractionOnBoard(0))
+	}
+	if RapidActingInsulinModel.FractionOnBoard(6*time.Hour) != 0 {
+		t.Errorf("expected 0, got %f", RapidActingInsulinModel.FractionOnBoard(6*time.Hour))
+	}
+
+	fraction := RapidActingInsulinModel.FractionOnBoard(2 * time.Hour)
+	if math.Abs(float64(fraction)-0.45) > 0.01 {
+		t.Errorf("expected 0.45, got %f", fraction)
+	}
+
+	previous := float32(1)
+	for elapsed := 5 * time.Minute; elapsed < 6*time.Hour; elapsed += 5 * time.Minute {
+		fraction := UltraRapidActingInsulinModel.FractionOnBoard(elapsed)
+		if fraction > previous || fraction < 0 {
+			t.Fatalf("expected a decreasing fraction at %s, got %f after %f", elapsed, fraction, previous)
+		}
+		if fraction > RapidActingInsulinModel.FractionOnBoard(elapsed) {
+			t.Errorf("expected ultra rapid-acting insulin to be absorbed faster at %s", elapsed)
+		}
+		previous = fraction
+	}
+}
+
+func TestNewInsulinModel(t *testing.T) {
+	model, err := NewInsulinModel("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Duration() != 4*time.Hour {
+		t.Errorf("expected 4h, got %s", model.Duration())
+	}
+
+	model, err = NewInsulinModel(RapidActingInsulin, 5*time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != (ExponentialInsulinModel{ActionDuration: 5 * time.Hour, PeakTime: 75 * time.Minute}) {
+		t.Errorf("unexpected model %+v", model)
+	}
+
+	for _, test := range []struct {
+		insulinType    InsulinType
+		actionDuration time.Duration
+		peakTime       time.Duration
+	}{
+		{insulinType: "regular"},
+		{insulinType: LegacyInsulin, actionDuration: 5 * time.Hour},
+		{insulinType: UltraRapidActingInsulin, peakTime: 3 * time.Hour},
+	} {
+		if _, err := NewInsulinModel(test.insulinType, test.actionDuration, test.peakTime); err == nil {
+			t.Errorf("expected error for %+v", test)
+		}
+	}
+}
+
+func TestDoseInsulinOnBoardFactorExponential(t *testing.T) {
+	dose, err := GetDose(DoseInput{
+		FoodInput: FoodInput{
+			InsulinToCarbRatio: SimpleTimeSensitiveFactor(5),
+		},
+		CorrectionInput: CorrectionInput{
+			CurrentBloodGlucoseLevelInMgDl: 100,
+			TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+			InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+		},
+		InsulinOnBoardInput: InsulinOnBoardInput{
+			Boluses: []Bolus{
+				{Time: time.Now().Add(-5 * time.Hour), UnitsOfInsulin: 4},
+			},
+			InsulinModel: RapidActingInsulinModel,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dose.Breakdown.InsulinOnBoardFactor >= 0 || dose.Breakdown.InsulinOnBoardFactor < -0.1 {
+		t.Errorf("expected a small amount of insulin on board, got %f", dose.Breakdown.InsulinOnBoardFactor)
+	}
+}
@@ -0,0 +1,122 @@
+package bolus
+
+import "fmt"
+
+type SafetyInput struct {
+	// Maximum Units of Insulin for a Bolus (upfront and extended together), 0 for no limit
+	MaxBolusUnitsOfInsulin float32
+	// Maximum Units of Insulin On Board including the Bolus, 0 for no limit
+	MaxInsulinOnBoard float32
+	// Blood Sugar (current or in 15 minutes) under which no insulin is recommended, 0 to disable
+	LowGlucoseSuspendThresholdInMgDl float32
+}
+
+type WarningCode string
+
+const (
+	// No insulin is recommended because Blood Sugar is under the Low Glucose Suspend Threshold
+	LowGlucoseSuspended WarningCode = "low_glucose_suspended"
+	// The Bolus was reduced so Insulin On Board stays under the Max Insulin On Board
+	MaxInsulinOnBoardExceeded WarningCode = "max_insulin_on_board_exceeded"
+	// The Bolus was reduced to the Max Bolus
+	MaxBolusExceeded WarningCode = "max_bolus_exceeded"
+	// No correction was made because the latest CGM reading is too old
+	StaleBloodGlucose WarningCode = "stale_blood_glucose"
+	// No correction was made because there is no CGM reading
+	BloodGlucoseUnavailable WarningCode = "blood_glucose_unavailable"
+	// No correction was made because the CGM trend is not computable
+	TrendNotComputable WarningCode = "trend_not_computable"
+	// A recent Dose was not confirmed as taken, so Insulin On Board may be missing its Bolus
+	UnconfirmedDose WarningCode = "unconfirmed_dose"
+)
+
+// Warning Messages for each reason a correction is not made
+var NoCorrectionMessageMap = map[WarningCode]string{
+	StaleBloodGlucose:       "no correction made, the latest CGM reading is too old, check blood sugar before dosing",
+	BloodGlucoseUnavailable: "no correction made, no CGM reading is available, check blood sugar before dosing",
+	TrendNotComputable:      "no correction made, the CGM trend is not computable, check blood sugar before dosing",
+}
+
+type Warning struct {
+	Code    WarningCode
+	Message string
+}
+
+// applySafetyLimits blocks or clips the insulin in a Dose according to the SafetyInput. When
+// clipping, the extended portion of the Bolus is reduced before the upfront portion.
+func applySafetyLimits(dose *Dose, input DoseInput) {
+	if input.CorrectionInput.NoCorrectionReason != "" {
+		dose.Warnings = append(dose.Warnings, Warning{
+			Code:    input.CorrectionInput.NoCorrectionReason,
+			Message: NoCorrectionMessageMap[input.CorrectionInput.NoCorrectionReason],
+		})
+	}
+
+	bloodSugar := input.CorrectionInput.CurrentBloodGlucoseLevelInMgDl
+	bloodSugarIn15Mins := bloodSugar + input.CorrectionInput.BloodGlucoseTrendInMgDlIn15Mins
+	if bloodSugarIn15Mins < bloodSugar {
+		bloodSugar = bloodSugarIn15Mins
+	}
+	// A low reading is suspended for even when it can't be relied on to correct with, as it may still be low
+	if bloodSugar > 0 && input.SafetyInput.LowGlucoseSuspendThresholdInMgDl > 0 && bloodSugar < input.SafetyInput.LowGlucoseSuspendThresholdInMgDl {
+		if dose.UnitsOfInsulin > 0 || dose.ExtendedUnitsOfInsulin > 0 {
+			dose.Warnings = append(dose.Warnings, Warning{
+				Code:    LowGlucoseSuspended,
+				Message: "no insulin recommended while blood sugar is under the low glucose suspend threshold, treat the low first",
+			})
+			clipInsulin(dose, 0)
+		}
+		return
+	}
+
+	insulinOnBoard := -dose.Breakdown.InsulinOnBoardFactor
+	if input.SafetyInput.MaxInsulinOnBoard > 0 && insulinOnBoard+totalInsulin(dose) > input.SafetyInput.MaxInsulinOnBoard {
+		limit := input.SafetyInput.MaxInsulinOnBoard - insulinOnBoard
+		if limit < 0 {
+			limit = 0
+		}
+		dose.Warnings = append(dose.Warnings, Warning{
+			Code:    MaxInsulinOnBoardExceeded,
+			Message: fmt.Sprintf("%.2f units reduced to %.2f units to keep insulin on board under %.2f units", totalInsulin(dose), limit, input.SafetyInput.MaxInsulinOnBoard),
+		})
+		clipInsulin(dose, limit)
+	}
+
+	if input.SafetyInput.MaxBolusUnitsOfInsulin > 0 && totalInsulin(dose) > input.SafetyInput.MaxBolusUnitsOfInsulin {
+		dose.Warnings = append(dose.Warnings, Warning{
+			Code:    MaxBolusExceeded,
+			Message: fmt.Sprintf("%.2f units reduced to the max bolus of %.2f units", totalInsulin(dose), input.SafetyInput.MaxBolusUnitsOfInsulin),
+		})
+		clipInsulin(dose, input.SafetyInput.MaxBolusUnitsOfInsulin)
+	}
+}
+
+func totalInsulin(dose *Dose) float32 {
+	total := dose.ExtendedUnitsOfInsulin
+	if dose.UnitsOfInsulin > 0 {
+		total += dose.UnitsOfInsulin
+	}
+	return total
+}
+
+func clipInsulin(dose *Dose, limit float32) {
+	upfront := dose.UnitsOfInsulin
+	if upfront < 0 {
+		upfront = 0
+	}
+	if upfront > limit {
+		upfront = limit
+	}
+	extended := limit - upfront
+	if extended > dose.ExtendedUnitsOfInsulin {
+		extended = dose.ExtendedUnitsOfInsulin
+	}
+
+	if dose.UnitsOfInsulin > 0 {
+		dose.UnitsOfInsulin = upfront
+	}
+	dose.ExtendedUnitsOfInsulin = extended
+	if dose.ExtendedUnitsOfInsulin == 0 {
+		dose.ExtendedDurationInMinutes = 0
+	}
+}
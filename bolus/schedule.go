@@ -0,0 +1,327 @@
+package bolus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A value that applies from a given time of day until the next entry in a schedule starts
+type ScheduledFactor struct {
+	// Time of day the value starts to apply, in minutes after midnight
+	StartMinute int
+	// Value of the factor
+	Value float32
+}
+
+// A TimeSensitiveFactor that changes throughout the day, for example an Insulin to Carb Ratio of
+// 8 from 06:00 to 11:00 and 12 otherwise. The latest entry wraps around midnight, so it applies
+// until the earliest entry starts.
+type ScheduledTimeSensitiveFactor []ScheduledFactor
+
+func (s ScheduledTimeSensitiveFactor) GetAtTime(t time.Time) float32 {
+	entry, ok := entryAtTime(s, t)
+	if !ok {
+		return 0
+	}
+	return entry.Value
+}
+
+// Convert returns a copy of the factor with every value passed through fn, for example to
+// convert an Insulin Sensitivity Factor between Glucose Units
+func (s ScheduledTimeSensitiveFactor) Convert(fn func(float32) float32) ScheduledTimeSensitiveFactor {
+	converted := make(ScheduledTimeSensitiveFactor, len(s))
+	for i, entry := range s {
+		converted[i] = ScheduledFactor{StartMinute: entry.StartMinute, Value: fn(entry.Value)}
+	}
+	return converted
+}
+
+// IsZero reports whether the factor has not been set
+func (s ScheduledTimeSensitiveFactor) IsZero() bool {
+	return len(s) == 0 || (len(s) == 1 && s[0].Value == 0)
+}
+
+func (s ScheduledFactor) startMinute() int {
+	return s.StartMinute
+}
+
+type scheduledFactorJSON struct {
+	Start string  `json:"start"`
+	Value float32 `json:"value"`
+}
+
+// MarshalJSON writes a factor that applies all day as a plain number, and a schedule as a list
+// of {"start": "HH:MM", "value": number} entries.
+func (s ScheduledTimeSensitiveFactor) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return json.Marshal(0)
+	}
+	if len(s) == 1 && s[0].StartMinute == 0 {
+		return json.Marshal(s[0].Value)
+	}
+
+	entries := make([]scheduledFactorJSON, len(s))
+	for i, entry := range s {
+		entries[i] = scheduledFactorJSON{
+			Start: formatTimeOfDay(entry.StartMinute),
+			Value: entry.Value,
+		}
+	}
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON accepts either a plain number that applies all day, or a list of
+// {"start": "HH:MM", "value": number} entries.
+func (s *ScheduledTimeSensitiveFactor) UnmarshalJSON(b []byte) error {
+	var value float32
+	if err := json.Unmarshal(b, &value); err == nil {
+		*s = ScheduledTimeSensitiveFactor{{Value: value}}
+		return nil
+	}
+
+	var entries []scheduledFactorJSON
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return errors.New(`expected a number or a list of {"start": "HH:MM", "value": number}`)
+	}
+	if len(entries) == 0 {
+		return errors.New("schedule requires at least one entry")
+	}
+
+	schedule := make(ScheduledTimeSensitiveFactor, len(entries))
+	for i, entry := range entries {
+		startMinute, err := parseTimeOfDay(entry.Start)
+		if err != nil {
+			return err
+		}
+		if entry.Value <= 0 {
+			return fmt.Errorf("schedule entry starting at %s must have a positive value", entry.Start)
+		}
+		schedule[i] = ScheduledFactor{StartMinute: startMinute, Value: entry.Value}
+	}
+	if err := sortSchedule(schedule); err != nil {
+		return err
+	}
+
+	*s = schedule
+	return nil
+}
+
+// A Target Blood Glucose Range in mg/dL. A single target has an equal Low and High.
+type TargetRange struct {
+	// Lower bound, below which a negative correction is made
+	Low float32
+	// Upper bound, above which a correction is made
+	High float32
+}
+
+func (r TargetRange) GetAtTime(time.Time) TargetRange {
+	return r
+}
+
+type TimeSensitiveTarget interface {
+	GetAtTime(time.Time) TargetRange
+}
+
+type SimpleTimeSensitiveTarget float32
+
+func (s SimpleTimeSensitiveTarget) GetAtTime(time.Time) TargetRange {
+	return TargetRange{Low: float32(s), High: float32(s)}
+}
+
+// A Target Range that applies from a given time of day until the next entry in a schedule starts
+type ScheduledTarget struct {
+	// Time of day the target starts to apply, in minutes after midnight
+	StartMinute int
+	TargetRange
+}
+
+func (s ScheduledTarget) startMinute() int {
+	return s.StartMinute
+}
+
+// A TimeSensitiveTarget that changes throughout the day, for example 120 overnight and 100
+// during the day. Like ScheduledTimeSensitiveFactor, the latest entry wraps around midnight.
+type ScheduledTimeSensitiveTarget []ScheduledTarget
+
+func (s ScheduledTimeSensitiveTarget) GetAtTime(t time.Time) TargetRange {
+	entry, ok := entryAtTime(s, t)
+	if !ok {
+		return TargetRange{}
+	}
+	return entry.TargetRange
+}
+
+// Convert returns a copy of the target with every bound passed through fn, for example to
+// convert between Glucose Units
+func (s ScheduledTimeSensitiveTarget) Convert(fn func(float32) float32) ScheduledTimeSensitiveTarget {
+	converted := make(ScheduledTimeSensitiveTarget, len(s))
+	for i, entry := range s {
+		converted[i] = ScheduledTarget{
+			StartMinute: entry.StartMinute,
+			TargetRange: TargetRange{Low: fn(entry.Low), High: fn(entry.High)},
+		}
+	}
+	return converted
+}
+
+// IsZero reports whether the target has not been set
+func (s ScheduledTimeSensitiveTarget) IsZero() bool {
+	return len(s) == 0 || (len(s) == 1 && s[0].TargetRange == TargetRange{})
+}
+
+type targetRangeJSON struct {
+	Low  float32 `json:"low"`
+	High float32 `json:"high"`
+}
+
+type scheduledTargetJSON struct {
+	Start string   `json:"start"`
+	Value *float32 `json:"value,omitempty"`
+	Low   *float32 `json:"low,omitempty"`
+	High  *float32 `json:"high,omitempty"`
+}
+
+// MarshalJSON writes a target that applies all day as a plain number or a {"low": number,
+// "high": number} range, and a schedule as a list of {"start": "HH:MM", "value": number} or
+// {"start": "HH:MM", "low": number, "high": number} entries.
+func (s ScheduledTimeSensitiveTarget) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return json.Marshal(0)
+	}
+	if len(s) == 1 && s[0].StartMinute == 0 {
+		if s[0].Low == s[0].High {
+			return json.Marshal(s[0].Low)
+		}
+		return json.Marshal(targetRangeJSON{Low: s[0].Low, High: s[0].High})
+	}
+
+	entries := make([]scheduledTargetJSON, len(s))
+	for i, entry := range s {
+		entries[i] = scheduledTargetJSON{Start: formatTimeOfDay(entry.StartMinute)}
+		if entry.Low == entry.High {
+			entries[i].Value = &entry.Low
+		} else {
+			entries[i].Low, entries[i].High = &entry.Low, &entry.High
+		}
+	}
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON accepts a plain number or a {"low": number, "high": number} range that applies
+// all day, or a list of {"start": "HH:MM", "value": number} or
+// {"start": "HH:MM", "low": number, "high": number} entries.
+func (s *ScheduledTimeSensitiveTarget) UnmarshalJSON(b []byte) error {
+	var value float32
+	if err := json.Unmarshal(b, &value); err == nil {
+		*s = ScheduledTimeSensitiveTarget{{TargetRange: TargetRange{Low: value, High: value}}}
+		return nil
+	}
+
+	var targetRange targetRangeJSON
+	if err := json.Unmarshal(b, &targetRange); err == nil {
+		if err := validateTargetRange(targetRange.Low, targetRange.High); err != nil {
+			return err
+		}
+		*s = ScheduledTimeSensitiveTarget{{TargetRange: TargetRange{Low: targetRange.Low, High: targetRange.High}}}
+		return nil
+	}
+
+	var entries []scheduledTargetJSON
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return errors.New(`expected a number, a {"low": number, "high": number} range, or a list of {"start": "HH:MM", "value": number} or {"start": "HH:MM", "low": number, "high": number}`)
+	}
+	if len(entries) == 0 {
+		return errors.New("schedule requires at least one entry")
+	}
+
+	schedule := make(ScheduledTimeSensitiveTarget, len(entries))
+	for i, entry := range entries {
+		startMinute, err := parseTimeOfDay(entry.Start)
+		if err != nil {
+			return err
+		}
+		schedule[i].StartMinute = startMinute
+		switch {
+		case entry.Value != nil && entry.Low == nil && entry.High == nil:
+			schedule[i].Low, schedule[i].High = *entry.Value, *entry.Value
+		case entry.Value == nil && entry.Low != nil && entry.High != nil:
+			schedule[i].Low, schedule[i].High = *entry.Low, *entry.High
+		default:
+			return fmt.Errorf("schedule entry starting at %s requires either a value, or a low and a high", entry.Start)
+		}
+		if err := validateTargetRange(schedule[i].Low, schedule[i].High); err != nil {
+			return err
+		}
+	}
+	if err := sortSchedule(schedule); err != nil {
+		return err
+	}
+
+	*s = schedule
+	return nil
+}
+
+func validateTargetRange(low float32, high float32) error {
+	if low <= 0 {
+		return errors.New("target range must have a positive low")
+	}
+	if low > high {
+		return fmt.Errorf("target range low (%v) must not be above high (%v)", low, high)
+	}
+	return nil
+}
+
+type scheduleEntry interface {
+	startMinute() int
+}
+
+// entryAtTime returns the entry of a schedule in effect at the given time of day
+func entryAtTime[E scheduleEntry](schedule []E, t time.Time) (E, bool) {
+	if len(schedule) == 0 {
+		var zero E
+		return zero, false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	current, latest := -1, 0
+	for i, entry := range schedule {
+		if entry.startMinute() <= minute && (current == -1 || entry.startMinute() > schedule[current].startMinute()) {
+			current = i
+		}
+		if entry.startMinute() > schedule[latest].startMinute() {
+			latest = i
+		}
+	}
+	if current == -1 {
+		current = latest
+	}
+	return schedule[current], true
+}
+
+// sortSchedule sorts a schedule by start time, and rejects entries starting at the same time
+func sortSchedule[E scheduleEntry](schedule []E) error {
+	sort.Slice(schedule, func(i, j int) bool {
+		return schedule[i].startMinute() < schedule[j].startMinute()
+	})
+	for i := 1; i < len(schedule); i++ {
+		if schedule[i].startMinute() == schedule[i-1].startMinute() {
+			return fmt.Errorf("schedule has more than one entry starting at %s", formatTimeOfDay(schedule[i].startMinute()))
+		}
+	}
+	return nil
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse start %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatTimeOfDay(minute int) string {
+	return fmt.Sprintf("%02d:%02d", minute/60, minute%60)
+}
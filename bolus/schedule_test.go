@@ -0,0 +1,124 @@
+package bolus
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestScheduledTimeSensitiveFactor(t *testing.T) {
+	var factor ScheduledTimeSensitiveFactor
+	err := json.Unmarshal([]byte(`[{"start": "11:00", "value": 12}, {"start": "06:00", "value": 8}]`), &factor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hour, expected := range map[int]float32{0: 12, 5: 12, 6: 8, 10: 8, 11: 12, 23: 12} {
+		value := factor.GetAtTime(time.Date(2025, 4, 7, hour, 30, 0, 0, location))
+		if value != expected {
+			t.Errorf("expected %f at %d:30, got %f", expected, hour, value)
+		}
+	}
+
+	b, err := json.Marshal(factor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `[{"start":"06:00","value":8},{"start":"11:00","value":12}]` {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestScheduledTimeSensitiveFactorNumber(t *testing.T) {
+	var factor ScheduledTimeSensitiveFactor
+	err := json.Unmarshal([]byte(`10`), &factor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if factor.GetAtTime(time.Now()) != 10 {
+		t.Errorf("expected 10, got %f", factor.GetAtTime(time.Now()))
+	}
+
+	b, err := json.Marshal(factor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `10` {
+		t.Errorf("expected 10, got %s", b)
+	}
+}
+
+func TestScheduledTimeSensitiveFactorInvalid(t *testing.T) {
+	for _, input := range []string{
+		`[]`,
+		`"10"`,
+		`[{"start": "6am", "value": 8}]`,
+		`[{"start": "06:00", "value": 0}]`,
+		`[{"start": "06:00", "value": 8}, {"start": "06:00", "value": 12}]`,
+	} {
+		var factor ScheduledTimeSensitiveFactor
+		if err := json.Unmarshal([]byte(input), &factor); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
+
+func TestScheduledTimeSensitiveTarget(t *testing.T) {
+	var target ScheduledTimeSensitiveTarget
+	err := json.Unmarshal([]byte(`[{"start": "22:00", "value": 120}, {"start": "07:00", "low": 90, "high": 110}]`), &target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for hour, expected := range map[int]TargetRange{
+		3:  {Low: 120, High: 120},
+		7:  {Low: 90, High: 110},
+		21: {Low: 90, High: 110},
+		22: {Low: 120, High: 120},
+	} {
+		value := target.GetAtTime(time.Date(2025, 4, 7, hour, 0, 0, 0, time.UTC))
+		if value != expected {
+			t.Errorf("expected %+v at %d:00, got %+v", expected, hour, value)
+		}
+	}
+
+	b, err := json.Marshal(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `[{"start":"07:00","low":90,"high":110},{"start":"22:00","value":120}]` {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestScheduledTimeSensitiveTargetRange(t *testing.T) {
+	var target ScheduledTimeSensitiveTarget
+	err := json.Unmarshal([]byte(`{"low": 100, "high": 120}`), &target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.GetAtTime(time.Now()) != (TargetRange{Low: 100, High: 120}) {
+		t.Errorf("expected 100-120, got %+v", target.GetAtTime(time.Now()))
+	}
+
+	b, err := json.Marshal(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"low":100,"high":120}` {
+		t.Errorf("unexpected JSON %s", b)
+	}
+
+	for _, input := range []string{
+		`{"low": 120, "high": 100}`,
+		`[{"start": "06:00", "value": 100, "low": 90}]`,
+	} {
+		if err := json.Unmarshal([]byte(input), &target); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
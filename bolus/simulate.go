@@ -0,0 +1,175 @@
+package bolus
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+type SimulationInput struct {
+	// The same input as for a dose, describing the Meal, Blood Glucose, and previous Boluses and Meals
+	DoseInput
+	// Units of Insulin to simulate taking upfront at the input's Time
+	UnitsOfInsulin float32
+	// Units of Insulin to simulate delivering evenly over ExtendedDurationInMinutes
+	ExtendedUnitsOfInsulin float32
+	// Minutes to extend ExtendedUnitsOfInsulin over (0 for the duration of the Meal's Fat-Protein Units, or
+	// upfront if there are none)
+	ExtendedDurationInMinutes float32
+	// Minutes for the Carbohydrates of the Meal to be absorbed (0 for DefaultCarbAbsorptionTime)
+	CarbAbsorptionTimeInMinutes float32
+}
+
+type GlucosePoint struct {
+	// Time of the projected Blood Glucose
+	Time time.Time
+	// Projected Blood Glucose
+	BloodGlucoseLevelInMgDl float32
+}
+
+type Simulation struct {
+	// Projected Blood Glucose every SimulationInterval, starting with the current Blood Glucose
+	Points []GlucosePoint
+	// Lowest projected Blood Glucose
+	Nadir GlucosePoint
+	// Highest projected Blood Glucose
+	Peak GlucosePoint
+	// Set when the Nadir is under LowThresholdInMgDl
+	PredictedLow bool
+	// The Low Glucose Suspend Threshold, or DefaultLowThresholdInMgDl if it is not set
+	LowThresholdInMgDl float32
+}
+
+// Time between each projected point of a Simulation
+const SimulationInterval = 5 * time.Minute
+
+// A Simulation lasts the Duration of Insulin Action, within these bounds
+const (
+	MinSimulationDuration = 4 * time.Hour
+	MaxSimulationDuration = 6 * time.Hour
+)
+
+// Blood Glucose under which a low is predicted when no Low Glucose Suspend Threshold is set
+const DefaultLowThresholdInMgDl = 70
+
+// Simulate projects Blood Glucose after taking a candidate Bolus, from the current Blood Glucose and
+// trend, the Insulin On Board (including the candidate Bolus) and Carbs On Board (including the
+// Meal). If the input is invalid, it returns ValidationErrors.
+func Simulate(input SimulationInput) (Simulation, error) {
+	now := localTime(input.DoseInput)
+
+	// Validate Params
+	errs := validate(input.DoseInput, now)
+	if input.CorrectionInput.NoCorrectionReason != "" {
+		errs.add("current_blood_glucose_level_in_mg_dl", "a current CGM reading is required to simulate ("+string(input.CorrectionInput.NoCorrectionReason)+")")
+	}
+	if input.UnitsOfInsulin < 0 {
+		errs.add("units_of_insulin", "must not be negative")
+	}
+	if input.ExtendedUnitsOfInsulin < 0 {
+		errs.add("extended_units_of_insulin", "must not be negative")
+	}
+	if input.ExtendedDurationInMinutes < 0 {
+		errs.add("extended_duration_in_minutes", "must not be negative")
+	}
+	if input.CarbAbsorptionTimeInMinutes < 0 || input.CarbAbsorptionTimeInMinutes > float32(MaxCarbAbsorptionTime.Minutes()) {
+		errs.add("carb_absorption_time_in_minutes", "must be between 0 and 480")
+	}
+	if len(errs) > 0 {
+		return Simulation{}, errs
+	}
+
+	// The dose accounts for the Meal (net Carbs and Fat-Protein Units) and Exercise
+	dose := calculateDose(input.DoseInput, now)
+
+	insulinModel := input.InsulinOnBoardInput.InsulinModel
+	if insulinModel == nil {
+		insulinModel = LegacyInsulinModel
+	}
+	duration := min(max(insulinModel.Duration(), MinSimulationDuration), MaxSimulationDuration)
+
+	// Add the candidate Bolus, with the extended portion delivered every interval
+	boluses := append([]Bolus{}, input.InsulinOnBoardInput.Boluses...)
+	upfront := input.UnitsOfInsulin
+	extendedDuration := input.ExtendedDurationInMinutes
+	if extendedDuration == 0 {
+		extendedDuration = dose.ExtendedDurationInMinutes
+	}
+	steps := int(math.Ceil(float64(extendedDuration) * float64(time.Minute) / float64(SimulationInterval)))
+	if steps == 0 {
+		upfront += input.ExtendedUnitsOfInsulin
+	} else if input.ExtendedUnitsOfInsulin > 0 {
+		for i := range steps {
+			boluses = append(boluses, Bolus{
+				Time:           now.Add(time.Duration(i) * SimulationInterval),
+				UnitsOfInsulin: input.ExtendedUnitsOfInsulin / float32(steps),
+			})
+		}
+	}
+	boluses = append(boluses, Bolus{Time: now, UnitsOfInsulin: upfront})
+
+	// Add the Meal, with Fat-Protein Units absorbed like Carbs over the extended duration
+	meals := append([]Meal{}, input.CarbsOnBoardInput.Meals...)
+	meals = append(meals, Meal{
+		Time:                    now,
+		GramsOfCarbs:            max(dose.Breakdown.NetGramsOfCarbs, 0),
+		AbsorptionTimeInMinutes: input.CarbAbsorptionTimeInMinutes,
+	})
+	if dose.Breakdown.FatProteinFactor > 0 {
+		meals = append(meals, Meal{
+			Time:                    now,
+			GramsOfCarbs:            dose.Breakdown.FatProteinUnits * 10 * input.FoodInput.FatProteinUnitMultiplier,
+			AbsorptionTimeInMinutes: dose.ExtendedDurationInMinutes,
+		})
+	}
+	carbAbsorptionModel := input.CarbsOnBoardInput.CarbAbsorptionModel
+
+	simulation := Simulation{LowThresholdInMgDl: input.SafetyInput.LowGlucoseSuspendThresholdInMgDl}
+	if simulation.LowThresholdInMgDl == 0 {
+		simulation.LowThresholdInMgDl = DefaultLowThresholdInMgDl
+	}
+
+	bloodSugar := input.CorrectionInput.CurrentBloodGlucoseLevelInMgDl
+	simulation.Points = append(simulation.Points, GlucosePoint{Time: now, BloodGlucoseLevelInMgDl: bloodSugar})
+	for elapsed := SimulationInterval; elapsed <= duration; elapsed += SimulationInterval {
+		previous, current := now.Add(elapsed-SimulationInterval), now.Add(elapsed)
+		insulinSensitivityFactor := input.CorrectionInput.InsulinSensitivityFactor.GetAtTime(current)
+		insulinToCarbRatio := input.FoodInput.InsulinToCarbRatio.GetAtTime(current)
+
+		// Insulin absorbed this interval, more effective when exercising
+		var units float32
+		for _, bolus := range boluses {
+			units += bolus.UnitsOfInsulin * (insulinModel.FractionOnBoard(previous.Sub(bolus.Time)) - insulinModel.FractionOnBoard(current.Sub(bolus.Time)))
+		}
+		bloodSugar -= units * insulinSensitivityFactor / dose.Breakdown.ExerciseMultiplier
+
+		// Carbs absorbed this interval
+		var grams float32
+		for _, meal := range meals {
+			grams += meal.GramsOfCarbs * (carbAbsorptionModel.FractionAbsorbed(current.Sub(meal.Time), meal.AbsorptionTime()) - carbAbsorptionModel.FractionAbsorbed(previous.Sub(meal.Time), meal.AbsorptionTime()))
+		}
+		bloodSugar += grams * insulinSensitivityFactor / insulinToCarbRatio
+
+		// The current trend continues for 15 minutes
+		if elapsed <= 15*time.Minute {
+			bloodSugar += input.CorrectionInput.BloodGlucoseTrendInMgDlIn15Mins * float32(SimulationInterval) / float32(15*time.Minute)
+		}
+
+		simulation.Points = append(simulation.Points, GlucosePoint{Time: current, BloodGlucoseLevelInMgDl: bloodSugar})
+	}
+
+	simulation.Nadir, simulation.Peak = simulation.Points[0], simulation.Points[0]
+	for _, point := range simulation.Points {
+		if point.BloodGlucoseLevelInMgDl < simulation.Nadir.BloodGlucoseLevelInMgDl {
+			simulation.Nadir = point
+		}
+		if point.BloodGlucoseLevelInMgDl > simulation.Peak.BloodGlucoseLevelInMgDl {
+			simulation.Peak = point
+		}
+	}
+	simulation.PredictedLow = simulation.Nadir.BloodGlucoseLevelInMgDl < simulation.LowThresholdInMgDl
+
+	log.Printf("SIMULATION at %s, Input: %+v, Nadir: %+v, Peak: %+v", now.String(), input, simulation.Nadir, simulation.Peak)
+
+	return simulation, nil
+}
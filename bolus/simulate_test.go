@@ -0,0 +1,115 @@
+package bolus
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	now := time.Date(2025, 4, 7, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name                string
+		currentBloodGlucose float32
+		totalGramsOfCarbs   float32
+		unitsOfInsulin      float32
+		expectedEnd         float32
+		expectedNadir       float32
+		expectedPeak        float32
+		expectedLow         bool
+	}{
+		{
+			name:                "correction",
+			currentBloodGlucose: 190,
+			unitsOfInsulin:      3,
+			expectedEnd:         100,
+			expectedNadir:       100,
+			expectedPeak:        190,
+		},
+		{
+			name:                "overdose",
+			currentBloodGlucose: 100,
+			unitsOfInsulin:      2,
+			expectedEnd:         40,
+			expectedNadir:       40,
+			expectedPeak:        100,
+			expectedLow:         true,
+		},
+		{
+			name:                "meal",
+			currentBloodGlucose: 100,
+			totalGramsOfCarbs:   50,
+			unitsOfInsulin:      5,
+			expectedEnd:         100,
+			expectedNadir:       100,
+			// All 50g absorbed after 30 minutes (+150), with 10% of the Bolus (-15)
+			expectedPeak: 235,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			simulation, err := Simulate(SimulationInput{
+				DoseInput: DoseInput{
+					Time: now,
+					FoodInput: FoodInput{
+						TotalGramsOfCarbs:  tc.totalGramsOfCarbs,
+						InsulinToCarbRatio: SimpleTimeSensitiveFactor(10),
+					},
+					CorrectionInput: CorrectionInput{
+						CurrentBloodGlucoseLevelInMgDl: tc.currentBloodGlucose,
+						TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+						InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+					},
+				},
+				UnitsOfInsulin:              tc.unitsOfInsulin,
+				CarbAbsorptionTimeInMinutes: 30,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(simulation.Points) != 49 {
+				t.Fatalf("expected 49 points over 4 hours, got %d", len(simulation.Points))
+			}
+			end := simulation.Points[len(simulation.Points)-1]
+			if !end.Time.Equal(now.Add(4 * time.Hour)) {
+				t.Errorf("expected the last point 4 hours from now, got %s", end.Time)
+			}
+			for _, value := range []struct {
+				name     string
+				expected float32
+				actual   float32
+			}{
+				{name: "end", expected: tc.expectedEnd, actual: end.BloodGlucoseLevelInMgDl},
+				{name: "nadir", expected: tc.expectedNadir, actual: simulation.Nadir.BloodGlucoseLevelInMgDl},
+				{name: "peak", expected: tc.expectedPeak, actual: simulation.Peak.BloodGlucoseLevelInMgDl},
+			} {
+				if math.Abs(float64(value.expected-value.actual)) > 0.01 {
+					t.Errorf("expected %s %f, got %f", value.name, value.expected, value.actual)
+				}
+			}
+			if simulation.PredictedLow != tc.expectedLow {
+				t.Errorf("expected predicted low %t, got %t", tc.expectedLow, simulation.PredictedLow)
+			}
+		})
+	}
+}
+
+func TestSimulateValidation(t *testing.T) {
+	_, err := Simulate(SimulationInput{
+		DoseInput: DoseInput{
+			FoodInput: FoodInput{
+				InsulinToCarbRatio: SimpleTimeSensitiveFactor(10),
+			},
+			CorrectionInput: CorrectionInput{
+				CurrentBloodGlucoseLevelInMgDl: 100,
+				TargetBloodGlucoseLevelInMgDl:  SimpleTimeSensitiveTarget(100),
+				InsulinSensitivityFactor:       SimpleTimeSensitiveFactor(30),
+			},
+		},
+		UnitsOfInsulin: -1,
+	})
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 1 || errs[0].Field != "units_of_insulin" {
+		t.Errorf("expected a units_of_insulin validation error, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package bolus
+
+import "math"
+
+type GlucoseUnit string
+
+const (
+	MgDl  GlucoseUnit = "mg/dL"
+	MmolL GlucoseUnit = "mmol/L"
+)
+
+// Milligrams per deciliter of Glucose in one millimole per liter
+const MgDlPerMmolL = 18.0182
+
+// ToMgDl converts a Blood Glucose value (or a difference of values) in the unit to mg/dL
+func (u GlucoseUnit) ToMgDl(value float32) float32 {
+	if u == MmolL {
+		return value * MgDlPerMmolL
+	}
+	return value
+}
+
+// FromMgDl converts a Blood Glucose value (or a difference of values) in mg/dL to the unit.
+// Values in mmol/L are rounded to one decimal place.
+func (u GlucoseUnit) FromMgDl(value float32) float32 {
+	if u == MmolL {
+		return float32(math.Round(float64(value)/MgDlPerMmolL*10) / 10)
+	}
+	return value
+}
+
+// Valid reports whether the unit is known (empty is mg/dL)
+func (u GlucoseUnit) Valid() bool {
+	return u == "" || u == MgDl || u == MmolL
+}
@@ -0,0 +1,46 @@
+package bolus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGlucoseUnit(t *testing.T) {
+	if MmolL.ToMgDl(5.5) != 5.5*MgDlPerMmolL {
+		t.Errorf("expected %f, got %f", 5.5*MgDlPerMmolL, MmolL.ToMgDl(5.5))
+	}
+	if MmolL.FromMgDl(100) != 5.5 {
+		t.Errorf("expected 5.5, got %f", MmolL.FromMgDl(100))
+	}
+	if MmolL.FromMgDl(MmolL.ToMgDl(6.1)) != 6.1 {
+		t.Errorf("expected 6.1, got %f", MmolL.FromMgDl(MmolL.ToMgDl(6.1)))
+	}
+	if MgDl.ToMgDl(100) != 100 || GlucoseUnit("").FromMgDl(100) != 100 {
+		t.Errorf("expected mg/dL values to be unchanged")
+	}
+}
+
+func TestScheduledTimeSensitiveTargetConvert(t *testing.T) {
+	target := ScheduledTimeSensitiveTarget{
+		{StartMinute: 0, TargetRange: TargetRange{Low: 5, High: 6}},
+	}
+	converted := target.Convert(MmolL.ToMgDl)
+	if converted.GetAtTime(time.Now()) != (TargetRange{Low: 5 * MgDlPerMmolL, High: 6 * MgDlPerMmolL}) {
+		t.Errorf("unexpected target %+v", converted.GetAtTime(time.Now()))
+	}
+	if target.GetAtTime(time.Now()) != (TargetRange{Low: 5, High: 6}) {
+		t.Errorf("expected original target to be unchanged, got %+v", target.GetAtTime(time.Now()))
+	}
+}
+
+func TestMinTargetBloodGlucoseLevelMessage(t *testing.T) {
+	for unit, expected := range map[GlucoseUnit]string{
+		"":    "must be set above 55 mg/dL",
+		MgDl:  "must be set above 55 mg/dL",
+		MmolL: "must be set above 3.1 mmol/L",
+	} {
+		if message := MinTargetBloodGlucoseLevelMessage(unit); message != expected {
+			t.Errorf("expected %q, got %q", expected, message)
+		}
+	}
+}
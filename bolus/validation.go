@@ -0,0 +1,108 @@
+package bolus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A ValidationError describes an invalid field of a DoseInput
+type ValidationError struct {
+	// Name of the field as used by the API, for example "insulin_to_carb_ratio"
+	Field string `json:"field"`
+	// Why the field is invalid
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors is returned by GetDose when one or more fields of a DoseInput are invalid
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationErrors) add(field string, message string) {
+	*e = append(*e, &ValidationError{Field: field, Message: message})
+}
+
+// Lowest Target Blood Glucose Level (exclusive) that a dose will be calculated for
+const MinTargetBloodGlucoseLevelInMgDl = 55
+
+// MinTargetBloodGlucoseLevelMessage is the message of the ValidationError for a Target Blood Glucose Level
+// that is not above MinTargetBloodGlucoseLevelInMgDl, with the limit in the given unit
+func MinTargetBloodGlucoseLevelMessage(unit GlucoseUnit) string {
+	if unit == "" {
+		unit = MgDl
+	}
+	return fmt.Sprintf("must be set above %g %s", unit.FromMgDl(MinTargetBloodGlucoseLevelInMgDl), unit)
+}
+
+func validate(input DoseInput, now time.Time) ValidationErrors {
+	var errs ValidationErrors
+
+	if input.FoodInput.InsulinToCarbRatio == nil || input.FoodInput.InsulinToCarbRatio.GetAtTime(now) <= 0 {
+		errs.add("insulin_to_carb_ratio", "must be set to a positive value")
+	}
+	if input.CorrectionInput.InsulinSensitivityFactor == nil || input.CorrectionInput.InsulinSensitivityFactor.GetAtTime(now) <= 0 {
+		errs.add("insulin_sensitivity_factor", "must be set to a positive value")
+	}
+	if input.CorrectionInput.TargetBloodGlucoseLevelInMgDl == nil || input.CorrectionInput.TargetBloodGlucoseLevelInMgDl.GetAtTime(now).Low <= MinTargetBloodGlucoseLevelInMgDl {
+		errs.add("target_blood_glucose", MinTargetBloodGlucoseLevelMessage(MgDl))
+	}
+	if reason := input.CorrectionInput.NoCorrectionReason; reason != "" {
+		if _, ok := NoCorrectionMessageMap[reason]; !ok {
+			errs.add("no_correction_reason", "must be one of stale_blood_glucose, blood_glucose_unavailable, or trend_not_computable")
+		}
+	} else if input.CorrectionInput.CurrentBloodGlucoseLevelInMgDl <= 0 {
+		errs.add("current_blood_glucose_level_in_mg_dl", "must be positive")
+	}
+
+	for _, food := range []struct {
+		field string
+		grams float32
+	}{
+		{field: "total_grams_of_carbs", grams: input.FoodInput.TotalGramsOfCarbs},
+		{field: "grams_of_fiber", grams: input.FoodInput.GramsOfFiber},
+		{field: "grams_of_sugar_alcohol", grams: input.FoodInput.GramsOfSugarAlcohol},
+		{field: "grams_of_protein", grams: input.FoodInput.GramsOfProtein},
+		{field: "grams_of_fat", grams: input.FoodInput.GramsOfFat},
+	} {
+		if food.grams < 0 {
+			errs.add(food.field, "must not be negative")
+		}
+	}
+
+	switch input.CarbsOnBoardInput.CarbAbsorptionModel {
+	case "", LinearCarbAbsorption, PiecewiseCarbAbsorption:
+	default:
+		errs.add("carb_absorption_model", "must be one of linear or piecewise")
+	}
+
+	if input.ExerciseInput.MinutesOfExercise < 0 {
+		errs.add("minutes_of_exercise", "must not be negative")
+	}
+	switch input.ExerciseInput.ExerciseIntensity {
+	case "", None, Low, Medium, High:
+	default:
+		errs.add("exercise_intensity", "must be one of none, low, medium, or high")
+	}
+
+	if _, ok := DeliveryIncrementMap[input.DeliveryInput.DeliveryDevice]; !ok && input.DeliveryInput.DeliveryDevice != "" {
+		errs.add("delivery_device", "must be one of half_unit_pen, whole_unit_pen, or pump")
+	}
+	switch input.DeliveryInput.RoundingPolicy {
+	case "", RoundDown, RoundNearest, RoundDownWhenFalling:
+	default:
+		errs.add("rounding_policy", "must be one of down, nearest, or down_when_falling")
+	}
+
+	return errs
+}
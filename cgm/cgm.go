@@ -0,0 +1,204 @@
+package cgm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// A Source of Blood Glucose readings, for example a CGM's cloud service
+type Source interface {
+	// CurrentReading returns the most recent reading, which may be stale. It returns ErrNoReadings when
+	// there are none, and gives up when the context is done.
+	CurrentReading(ctx context.Context) (Reading, error)
+	// Name of the Source, for example "dexcom"
+	Name() string
+}
+
+// A Source that also returns the readings before its current one, so the readings between polls are not
+// missed
+type RecentSource interface {
+	Source
+	// RecentReadings returns the most recent readings, most recent (the current reading) first. It returns
+	// ErrNoReadings when there are none.
+	RecentReadings(ctx context.Context) ([]Reading, error)
+}
+
+type Reading struct {
+	// Time of the reading
+	Time time.Time
+	// Blood Glucose
+	ValueInMgDl float32
+	// Expected change in Blood Glucose over the next 15 minutes
+	TrendInMgDlIn15Mins float32
+	// How TrendInMgDlIn15Mins was determined
+	TrendMethod TrendMethod
+}
+
+// How the 15 minute Blood Glucose Trend was determined
+type TrendMethod string
+
+const (
+	// Fitted to the recent readings (least squares)
+	FittedTrend TrendMethod = "fitted"
+	// Looked up from the CGM's trend arrow
+	ArrowTrend TrendMethod = "arrow"
+	// Neither fitted nor looked up, as the trend arrow is not computable
+	NoTrend TrendMethod = "none"
+	// Entered manually with the reading
+	ManualTrend TrendMethod = "manual"
+)
+
+// Timeout of the HTTP requests to a CGM's cloud service, unless the Source is given its own HTTP client
+const DefaultTimeout = 10 * time.Second
+
+// Trend arrow of a reading
+type TrendArrow string
+
+const (
+	RisingQuickly  TrendArrow = "rising_quickly"
+	Rising         TrendArrow = "rising"
+	RisingSlowly   TrendArrow = "rising_slowly"
+	Flat           TrendArrow = "flat"
+	FallingSlowly  TrendArrow = "falling_slowly"
+	Falling        TrendArrow = "falling"
+	FallingQuickly TrendArrow = "falling_quickly"
+)
+
+// Change in Blood Glucose over 15 minutes in mg/dL for each trend arrow, see
+// https://www.dexcom.com/all-access/dexcom-cgm-explained/trend-arrow-and-treatment-decisions
+var TrendArrowToDeltaMap = map[TrendArrow]float32{
+	RisingQuickly:  45,
+	Rising:         30,
+	RisingSlowly:   15,
+	Flat:           0,
+	FallingSlowly:  -15,
+	Falling:        -30,
+	FallingQuickly: -45,
+}
+
+// Trend arrows as named by Dexcom (and Nightscout, which uses the same names). Other names (like
+// "NotComputable") do not give a trend.
+var DirectionToTrendArrowMap = map[string]TrendArrow{
+	"DoubleUp":      RisingQuickly,
+	"SingleUp":      Rising,
+	"FortyFiveUp":   RisingSlowly,
+	"Flat":          Flat,
+	"FortyFiveDown": FallingSlowly,
+	"SingleDown":    Falling,
+	"DoubleDown":    FallingQuickly,
+}
+
+// A Source that reports the state of its connection to the CGM's cloud service
+type StatusReporter interface {
+	Status() Status
+}
+
+// State of the connection to a CGM's cloud service
+type ConnectionState string
+
+const (
+	// Not logged in yet, as no readings have been requested
+	NotConnected ConnectionState = "not_connected"
+	Connected    ConnectionState = "connected"
+	// The credentials were rejected
+	AuthFailed ConnectionState = "auth_failed"
+	// Too many requests or login attempts
+	RateLimited ConnectionState = "rate_limited"
+	// The service could not be reached, or responded with an error
+	Unavailable ConnectionState = "unavailable"
+)
+
+type Status struct {
+	State ConnectionState
+	// Error of the last request, unset when it succeeded
+	Error string
+	// Time of the last request, unset when there has been none
+	LastChecked *time.Time
+	// Time of the last successful request, unset when there has been none
+	LastConnected *time.Time
+}
+
+// Returned by a Source when it has no readings
+var ErrNoReadings = errors.New("no CGM readings available")
+
+// A Source without a CGM, for Blood Glucose entered manually with each dose
+type ManualSource struct{}
+
+func (ManualSource) CurrentReading(ctx context.Context) (Reading, error) {
+	return Reading{}, ErrNoReadings
+}
+
+func (ManualSource) Name() string {
+	return "manual"
+}
+
+type Point struct {
+	Time        time.Time
+	ValueInMgDl float32
+}
+
+const (
+	// Minutes before the most recent reading to fit a trend to
+	RecentReadingsMinutes = 20
+	// Fewer recent readings than this fall back to the trend arrow
+	MinReadingsToFitTrend = 3
+)
+
+// FitRateOfChange returns the least squares slope in mg/dL per minute of the points within
+// RecentReadingsMinutes of the most recent point, or false if there are too few of them at distinct times
+func FitRateOfChange(points []Point) (float64, bool) {
+	if len(points) < MinReadingsToFitTrend {
+		return 0, false
+	}
+
+	latest := points[0].Time
+	for _, point := range points {
+		if point.Time.After(latest) {
+			latest = point.Time
+		}
+	}
+	// Times are minutes before the most recent point, so they stay small enough to fit precisely
+	var times, values []float64
+	distinctTimes := map[time.Time]bool{}
+	for _, point := range points {
+		age := latest.Sub(point.Time)
+		if age > RecentReadingsMinutes*time.Minute {
+			continue
+		}
+		times = append(times, -age.Minutes())
+		values = append(values, float64(point.ValueInMgDl))
+		distinctTimes[point.Time.Truncate(time.Second)] = true
+	}
+	if len(distinctTimes) < MinReadingsToFitTrend {
+		return 0, false
+	}
+
+	var meanTime, meanValue float64
+	for i := range times {
+		meanTime += times[i] / float64(len(times))
+		meanValue += values[i] / float64(len(values))
+	}
+	var covariance, variance float64
+	for i := range times {
+		covariance += (times[i] - meanTime) * (values[i] - meanValue)
+		variance += (times[i] - meanTime) * (times[i] - meanTime)
+	}
+	// Readings less than a second apart can't be fitted to a rate of change per minute
+	if variance < 1e-6 {
+		return 0, false
+	}
+	return covariance / variance, true
+}
+
+// Trend projects the change in Blood Glucose over the next 15 minutes from the rate of change fitted to
+// the points, or from the trend arrow's delta (if it is computable) when there are too few of them
+func Trend(points []Point, arrowDelta float32, arrowComputable bool) (float32, TrendMethod) {
+	if rate, ok := FitRateOfChange(points); ok {
+		return float32(rate * 15), FittedTrend
+	}
+	if !arrowComputable {
+		return 0, NoTrend
+	}
+	return arrowDelta, ArrowTrend
+}
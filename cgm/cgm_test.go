@@ -0,0 +1,51 @@
+package cgm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrend(t *testing.T) {
+	now := time.Now()
+	points := []Point{
+		{Time: now, ValueInMgDl: 100},
+		{Time: now.Add(-5 * time.Minute), ValueInMgDl: 110},
+		{Time: now.Add(-10 * time.Minute), ValueInMgDl: 120},
+		// Too old to fit to
+		{Time: now.Add(-25 * time.Minute), ValueInMgDl: 300},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		points          []Point
+		arrowComputable bool
+		expectedTrend   float32
+		expectedMethod  TrendMethod
+	}{
+		{name: "fitted", points: points, arrowComputable: true, expectedTrend: -30, expectedMethod: FittedTrend},
+		{name: "fitted without arrow", points: points, expectedTrend: -30, expectedMethod: FittedTrend},
+		{name: "arrow", points: points[:2], arrowComputable: true, expectedTrend: -15, expectedMethod: ArrowTrend},
+		{name: "none", points: points[:2], expectedTrend: 0, expectedMethod: NoTrend},
+		{name: "same time", points: []Point{points[0], points[0], points[0]}, arrowComputable: true, expectedTrend: -15, expectedMethod: ArrowTrend},
+		{name: "two distinct times", points: []Point{points[0], points[0], points[1]}, arrowComputable: true, expectedTrend: -15, expectedMethod: ArrowTrend},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			trend, method := Trend(tc.points, -15, tc.arrowComputable)
+			if trend != tc.expectedTrend {
+				t.Errorf("expected %f, got %f", tc.expectedTrend, trend)
+			}
+			if method != tc.expectedMethod {
+				t.Errorf("expected %s, got %s", tc.expectedMethod, method)
+			}
+		})
+	}
+}
+
+func TestManualSource(t *testing.T) {
+	_, err := ManualSource{}.CurrentReading(context.Background())
+	if !errors.Is(err, ErrNoReadings) {
+		t.Errorf("expected %v, got %v", ErrNoReadings, err)
+	}
+}
@@ -0,0 +1,181 @@
+package cgm
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// CGMs read every 5 minutes
+	DefaultPollInterval = 5 * time.Minute
+	// Readings older than this are dropped
+	DefaultHistoryDuration = 24 * time.Hour
+)
+
+// A Poller reads a Source in the background, keeping its readings in memory. It is a Source itself,
+// returning the most recent reading when it is less than the Poll Interval old.
+type Poller struct {
+	Source Source
+	// How often to poll the Source
+	Interval time.Duration
+	// How long to keep readings for
+	HistoryDuration time.Duration
+
+	now func() time.Time
+	// Holds a value while the Source is read, serializing requests to it (as it may not be safe for
+	// concurrent use) while letting a waiting Poll give up when its context is done
+	sourceSem chan struct{}
+	mu        sync.Mutex
+	// Oldest first
+	readings []Reading
+}
+
+type PollerInput struct {
+	Source Source
+	// Defaults to DefaultPollInterval
+	Interval time.Duration
+	// Defaults to DefaultHistoryDuration
+	HistoryDuration time.Duration
+	// Defaults to time.Now
+	Now func() time.Time
+}
+
+func NewPoller(input PollerInput) *Poller {
+	poller := &Poller{
+		Source:          input.Source,
+		Interval:        input.Interval,
+		HistoryDuration: input.HistoryDuration,
+		now:             input.Now,
+		sourceSem:       make(chan struct{}, 1),
+	}
+	if poller.Interval == 0 {
+		poller.Interval = DefaultPollInterval
+	}
+	if poller.HistoryDuration == 0 {
+		poller.HistoryDuration = DefaultHistoryDuration
+	}
+	if poller.now == nil {
+		poller.now = time.Now
+	}
+	return poller
+}
+
+// Run polls the Source every Interval until the context is done
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		_, err := p.Poll(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads the Source, recording its current reading (and its recent readings, if it is a RecentSource).
+// It waits for any other read of the Source to finish first, unless the context is done.
+func (p *Poller) Poll(ctx context.Context) (Reading, error) {
+	select {
+	case p.sourceSem <- struct{}{}:
+	case <-ctx.Done():
+		return Reading{}, ctx.Err()
+	}
+	readings, err := p.read(ctx)
+	<-p.sourceSem
+	if err != nil {
+		return Reading{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, reading := range readings {
+		p.record(reading)
+	}
+	cutoff := p.now().Add(-p.HistoryDuration)
+	for len(p.readings) > 0 && p.readings[0].Time.Before(cutoff) {
+		p.readings = p.readings[1:]
+	}
+	return readings[0], nil
+}
+
+// read returns the current reading of the Source, followed by its recent readings if it is a RecentSource
+func (p *Poller) read(ctx context.Context) ([]Reading, error) {
+	recentSource, ok := p.Source.(RecentSource)
+	if !ok {
+		reading, err := p.Source.CurrentReading(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []Reading{reading}, nil
+	}
+
+	readings, err := recentSource.RecentReadings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(readings) == 0 {
+		return nil, ErrNoReadings
+	}
+	return readings, nil
+}
+
+// record adds a reading in time order, unless there is one at the same time already
+func (p *Poller) record(reading Reading) {
+	i, found := slices.BinarySearchFunc(p.readings, reading.Time, func(r Reading, t time.Time) int {
+		return r.Time.Compare(t)
+	})
+	if !found {
+		p.readings = slices.Insert(p.readings, i, reading)
+	}
+}
+
+// CurrentReading implements Source, returning the most recent reading if it is less than the Interval
+// old, and polling the Source otherwise
+func (p *Poller) CurrentReading(ctx context.Context) (Reading, error) {
+	return p.CurrentReadingWithin(ctx, p.Interval)
+}
+
+// CurrentReadingWithin returns the most recent reading if it is less than maxAge old, and polls the Source
+// otherwise. The reading polled may still be older, when the Source has no newer one.
+func (p *Poller) CurrentReadingWithin(ctx context.Context, maxAge time.Duration) (Reading, error) {
+	p.mu.Lock()
+	var reading Reading
+	fresh := false
+	if len(p.readings) > 0 {
+		reading = p.readings[len(p.readings)-1]
+		fresh = p.now().Sub(reading.Time) < maxAge
+	}
+	p.mu.Unlock()
+
+	if fresh {
+		return reading, nil
+	}
+	return p.Poll(ctx)
+}
+
+func (p *Poller) Name() string {
+	return p.Source.Name()
+}
+
+// Readings returns the readings since a time, oldest first
+func (p *Poller) Readings(since time.Time) []Reading {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var readings []Reading
+	for _, reading := range p.readings {
+		if !reading.Time.Before(since) {
+			readings = append(readings, reading)
+		}
+	}
+	return readings
+}
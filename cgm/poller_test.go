@@ -0,0 +1,226 @@
+package cgm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeSource returns a new reading, 5 minutes after the last, on every request
+type fakeSource struct {
+	start    time.Time
+	requests int
+	err      error
+}
+
+func (f *fakeSource) CurrentReading(ctx context.Context) (Reading, error) {
+	if f.err != nil {
+		return Reading{}, f.err
+	}
+	reading := Reading{Time: f.start.Add(time.Duration(f.requests) * 5 * time.Minute), ValueInMgDl: 100 + float32(f.requests)}
+	f.requests++
+	return reading, nil
+}
+
+func (f *fakeSource) Name() string {
+	return "fake"
+}
+
+func TestPollerCache(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := &fakeSource{start: now}
+	poller := NewPoller(PollerInput{Source: source, Now: func() time.Time { return now }})
+
+	reading, err := poller.CurrentReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.ValueInMgDl != 100 || source.requests != 1 {
+		t.Errorf("expected 100 from 1 request, got %f from %d", reading.ValueInMgDl, source.requests)
+	}
+
+	// Fresh, served from the cache
+	now = now.Add(4 * time.Minute)
+	reading, err = poller.CurrentReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.ValueInMgDl != 100 || source.requests != 1 {
+		t.Errorf("expected 100 from 1 request, got %f from %d", reading.ValueInMgDl, source.requests)
+	}
+
+	// Stale, polled again
+	now = now.Add(time.Minute)
+	reading, err = poller.CurrentReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.ValueInMgDl != 101 || source.requests != 2 {
+		t.Errorf("expected 101 from 2 requests, got %f from %d", reading.ValueInMgDl, source.requests)
+	}
+
+	source.err = ErrNoReadings
+	now = now.Add(5 * time.Minute)
+	_, err = poller.CurrentReading(context.Background())
+	if !errors.Is(err, ErrNoReadings) {
+		t.Errorf("expected no readings, got %v", err)
+	}
+}
+
+func TestPollerReadings(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := &fakeSource{start: now}
+	poller := NewPoller(PollerInput{Source: source, HistoryDuration: time.Hour, Now: func() time.Time { return now }})
+
+	// 2 hours of readings, with the last one polled twice
+	for range 24 {
+		_, err := poller.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(5 * time.Minute)
+	}
+	now = now.Add(-5 * time.Minute)
+	source.requests--
+	poller.Poll(context.Background())
+
+	readings := poller.Readings(time.Time{})
+	if len(readings) != 13 {
+		t.Fatalf("expected 13 readings in the last hour, got %d", len(readings))
+	}
+	if readings[0].ValueInMgDl != 111 || readings[12].ValueInMgDl != 123 {
+		t.Errorf("expected 111 to 123, got %f to %f", readings[0].ValueInMgDl, readings[12].ValueInMgDl)
+	}
+
+	readings = poller.Readings(now.Add(-10 * time.Minute))
+	if len(readings) != 3 {
+		t.Errorf("expected 3 readings in the last 10 minutes, got %d", len(readings))
+	}
+}
+
+func TestPollerCurrentReadingWithin(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	// The Source's readings are uploaded 3 minutes late
+	source := &fakeSource{start: now.Add(-3 * time.Minute)}
+	poller := NewPoller(PollerInput{Source: source, Now: func() time.Time { return now }})
+
+	_, err := poller.CurrentReadingWithin(context.Background(), 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Polled a minute ago, but the reading is 4 minutes old
+	now = now.Add(time.Minute)
+	reading, err := poller.CurrentReadingWithin(context.Background(), 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.requests != 1 {
+		t.Errorf("expected 1 request, got %d", source.requests)
+	}
+	reading, err = poller.CurrentReadingWithin(context.Background(), 4*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.ValueInMgDl != 101 || source.requests != 2 {
+		t.Errorf("expected 101 from 2 requests, got %f from %d", reading.ValueInMgDl, source.requests)
+	}
+}
+
+// recentSource returns the same recent readings, most recent first, on every request
+type recentSource struct {
+	readings []Reading
+}
+
+func (r *recentSource) CurrentReading(ctx context.Context) (Reading, error) {
+	return r.readings[0], nil
+}
+
+func (r *recentSource) RecentReadings(ctx context.Context) ([]Reading, error) {
+	return r.readings, nil
+}
+
+func (r *recentSource) Name() string {
+	return "recent"
+}
+
+func TestPollerRecentReadings(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	source := &recentSource{}
+	for i := range 5 {
+		source.readings = append(source.readings, Reading{Time: now.Add(time.Duration(-5*i) * time.Minute), ValueInMgDl: 100 - float32(i)})
+	}
+	poller := NewPoller(PollerInput{Source: source, Now: func() time.Time { return now }})
+
+	// The recent readings are recorded with the current one, and only once
+	reading, err := poller.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.ValueInMgDl != 100 {
+		t.Errorf("expected 100, got %f", reading.ValueInMgDl)
+	}
+	poller.Poll(context.Background())
+	readings := poller.Readings(time.Time{})
+	if len(readings) != 5 {
+		t.Fatalf("expected 5 readings, got %d", len(readings))
+	}
+	for i, reading := range readings {
+		if expected := 96 + float32(i); reading.ValueInMgDl != expected {
+			t.Errorf("expected %f, got %f", expected, reading.ValueInMgDl)
+		}
+	}
+}
+
+func TestPollerRun(t *testing.T) {
+	source := &fakeSource{start: time.Now()}
+	poller := NewPoller(PollerInput{Source: source, Interval: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	poller.Run(ctx)
+
+	if len(poller.Readings(time.Time{})) < 2 {
+		t.Errorf("expected readings to be polled, got %d", len(poller.Readings(time.Time{})))
+	}
+}
+
+// blockingSource blocks every request until release is closed
+type blockingSource struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSource) CurrentReading(ctx context.Context) (Reading, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return Reading{Time: time.Now()}, nil
+}
+
+func (b *blockingSource) Name() string {
+	return "blocking"
+}
+
+func TestPollerPollContext(t *testing.T) {
+	source := &blockingSource{started: make(chan struct{}, 1), release: make(chan struct{})}
+	poller := NewPoller(PollerInput{Source: source})
+
+	done := make(chan struct{})
+	go func() {
+		poller.Poll(context.Background())
+		close(done)
+	}()
+	<-source.started
+
+	// Waiting for the first Poll to finish gives up when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := poller.Poll(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	close(source.release)
+	<-done
+}
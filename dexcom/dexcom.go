@@ -4,16 +4,21 @@ package dexcom
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/kennedyjustin/BolusGPT/cgm"
 )
 
 const (
+	// Application ID and Base URL in the US
 	ApplicationId           = "d89443d2-327c-4a6f-89e5-496bbb0317db"
 	BaseUrl                 = "https://share2.dexcom.com/ShareWebServices/Services"
 	AuthEndpoint            = "/General/AuthenticatePublisherAccount"
@@ -21,35 +26,152 @@ const (
 	GlucoseReadingsEndpoint = "/Publisher/ReadPublisherLatestGlucoseValues"
 )
 
+type Region string
+
+const (
+	US  Region = "us"
+	OUS Region = "ous"
+	JP  Region = "jp"
+)
+
+var RegionToBaseUrlMap = map[Region]string{
+	US:  BaseUrl,
+	OUS: "https://shareous1.dexcom.com/ShareWebServices/Services",
+	JP:  "https://share.dexcom.jp/ShareWebServices/Services",
+}
+
+var RegionToApplicationIdMap = map[Region]string{
+	US:  ApplicationId,
+	OUS: ApplicationId,
+	JP:  "d8665ade-9673-4e27-9ff6-92db4ce13d13",
+}
+
 type Client struct {
-	Username  string
-	Password  string
-	AccountId string
-	SessionId string
+	Username      string
+	Password      string
+	BaseUrl       string
+	ApplicationId string
+	AccountId     string
+	SessionId     string
+	HTTPClient    *http.Client
+	// Times to retry a request after a network error or server error
+	MaxRetries int
+	// Wait before the first retry, doubling for each retry after
+	RetryBackoff time.Duration
+
+	// Guards status, which is read while readings are requested
+	statusMu sync.Mutex
+	status   cgm.Status
 }
 
 type ClientInput struct {
 	Username string
 	Password string
+	// Region of the Dexcom account (defaults to US)
+	Region Region
+	// Overrides the Base URL of the Region, for example to test against a fake server
+	BaseUrl string
+	// Defaults to a client with a Timeout of cgm.DefaultTimeout
+	HTTPClient *http.Client
+	// Defaults to DefaultMaxRetries, -1 to not retry
+	MaxRetries int
+	// Defaults to DefaultRetryBackoff
+	RetryBackoff time.Duration
 }
 
+const (
+	DefaultMaxRetries   = 2
+	DefaultRetryBackoff = 500 * time.Millisecond
+)
+
+// NewClient configures a client for the account. It logs in when a reading is first requested, so Dexcom
+// Share being down does not keep the server from starting.
 func NewClient(input ClientInput) (*Client, error) {
+	region := input.Region
+	if region == "" {
+		region = US
+	}
+	if _, ok := RegionToBaseUrlMap[region]; !ok {
+		return nil, errors.New("unknown dexcom region: " + string(region))
+	}
+
 	client := &Client{
-		Username: input.Username,
-		Password: input.Password,
+		Username:      input.Username,
+		Password:      input.Password,
+		BaseUrl:       RegionToBaseUrlMap[region],
+		ApplicationId: RegionToApplicationIdMap[region],
+		status:        cgm.Status{State: cgm.NotConnected},
+	}
+	if input.BaseUrl != "" {
+		client.BaseUrl = input.BaseUrl
+	}
+	client.HTTPClient = input.HTTPClient
+	if client.HTTPClient == nil {
+		client.HTTPClient = &http.Client{Timeout: cgm.DefaultTimeout}
+	}
+	switch {
+	case input.MaxRetries < 0:
+		client.MaxRetries = 0
+	case input.MaxRetries == 0:
+		client.MaxRetries = DefaultMaxRetries
+	default:
+		client.MaxRetries = input.MaxRetries
+	}
+	client.RetryBackoff = input.RetryBackoff
+	if client.RetryBackoff == 0 {
+		client.RetryBackoff = DefaultRetryBackoff
 	}
 
-	err := client.RetrieveAccountId()
-	if err != nil {
-		return nil, err
+	return client, nil
+}
+
+// Login retrieves a Session ID, retrieving the Account ID first if there is none. When the Account ID
+// is rejected, it is retrieved again.
+func (c *Client) Login(ctx context.Context) error {
+	retrievedAccountId := false
+	if c.AccountId == "" {
+		err := c.RetrieveAccountId(ctx)
+		if err != nil {
+			return err
+		}
+		retrievedAccountId = true
 	}
 
-	err = client.RetrieveSessionId()
-	if err != nil {
-		return nil, err
+	err := c.RetrieveSessionId(ctx)
+	if !retrievedAccountId && (errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrSessionExpired)) {
+		c.AccountId = ""
+		return c.Login(ctx)
 	}
+	return err
+}
 
-	return client, nil
+// Status implements cgm.StatusReporter, reporting the result of the last request for readings
+func (c *Client) Status() cgm.Status {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.status
+}
+
+// recordStatus updates the Status with the result of a request for readings
+func (c *Client) recordStatus(err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	now := time.Now()
+	c.status.LastChecked = &now
+	c.status.Error = ""
+	switch {
+	case err == nil || errors.Is(err, ErrNoReadings):
+		c.status.State = cgm.Connected
+		c.status.LastConnected = &now
+		return
+	case errors.Is(err, ErrAuthFailed):
+		c.status.State = cgm.AuthFailed
+	case errors.Is(err, ErrRateLimited):
+		c.status.State = cgm.RateLimited
+	default:
+		c.status.State = cgm.Unavailable
+	}
+	c.status.Error = err.Error()
 }
 
 type AuthRequest struct {
@@ -58,35 +180,21 @@ type AuthRequest struct {
 	ApplicationId string `json:"applicationId"`
 }
 
-func (c *Client) RetrieveAccountId() error {
+// Returned by Dexcom Share instead of an Account ID or Session ID when the password is wrong
+const DefaultUuid = "00000000-0000-0000-0000-000000000000"
+
+func (c *Client) RetrieveAccountId(ctx context.Context) error {
 	authRequest := AuthRequest{
 		AccountName:   c.Username,
 		Password:      c.Password,
-		ApplicationId: ApplicationId,
-	}
-
-	requestBody, err := json.Marshal(&authRequest)
-	if err != nil {
-		return err
+		ApplicationId: c.ApplicationId,
 	}
 
-	response, err := http.Post(BaseUrl+AuthEndpoint, "application/json", bytes.NewBuffer(requestBody))
+	accountId, err := c.retrieveId(ctx, AuthEndpoint, &authRequest)
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
-
-	responseBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return err
-	}
-	c.AccountId = strings.Trim(string(responseBody), "\"")
-
-	err = uuid.Validate(c.AccountId)
-	if err != nil {
-		log.Println(c.AccountId)
-		return err
-	}
+	c.AccountId = accountId
 
 	return nil
 }
@@ -97,34 +205,94 @@ type LoginRequest struct {
 	ApplicationId string `json:"applicationId"`
 }
 
-func (c *Client) RetrieveSessionId() error {
+func (c *Client) RetrieveSessionId(ctx context.Context) error {
 	loginRequest := LoginRequest{
 		AccountId:     c.AccountId,
 		Password:      c.Password,
-		ApplicationId: ApplicationId,
+		ApplicationId: c.ApplicationId,
 	}
 
-	requestBody, err := json.Marshal(&loginRequest)
+	sessionId, err := c.retrieveId(ctx, LoginEndpoint, &loginRequest)
 	if err != nil {
 		return err
 	}
+	c.SessionId = sessionId
 
-	response, err := http.Post(BaseUrl+LoginEndpoint, "application/json", bytes.NewBuffer(requestBody))
+	return nil
+}
+
+// retrieveId posts to an endpoint that responds with an Account ID or Session ID
+func (c *Client) retrieveId(ctx context.Context, endpoint string, request any) (string, error) {
+	var id string
+	err := c.post(ctx, endpoint, request, &id)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer response.Body.Close()
 
-	responseBody, err := io.ReadAll(response.Body)
+	err = uuid.Validate(id)
 	if err != nil {
-		return err
+		log.Println(id)
+		return "", err
 	}
-	c.SessionId = strings.Trim(string(responseBody), "\"")
-	err = uuid.Validate(c.SessionId)
+	if id == DefaultUuid {
+		return "", ErrAuthFailed
+	}
+
+	return id, nil
+}
+
+// post sends a request to Dexcom Share and decodes the response, retrying network errors and server
+// errors up to MaxRetries times. Error responses are returned as a *ShareError.
+func (c *Client) post(ctx context.Context, endpoint string, request any, response any) error {
+	requestBody, err := json.Marshal(request)
 	if err != nil {
-		log.Println(c.SessionId)
 		return err
 	}
 
-	return nil
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		var temporary bool
+		err, temporary = c.postOnce(ctx, endpoint, requestBody, response)
+		if err == nil || !temporary || attempt >= c.MaxRetries {
+			return err
+		}
+		log.Printf("retrying %s in %s: %s", endpoint, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postOnce sends a request to Dexcom Share once, reporting whether an error may be temporary
+func (c *Client) postOnce(ctx context.Context, endpoint string, requestBody []byte, response any) (error, bool) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseUrl+endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return err, false
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	httpResponse, err := c.HTTPClient.Do(request)
+	if err != nil {
+		// Network errors are temporary, unless the context is done
+		return err, ctx.Err() == nil
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err, true
+	}
+
+	if httpResponse.StatusCode >= 300 {
+		shareError := &ShareError{StatusCode: httpResponse.StatusCode}
+		json.Unmarshal(responseBody, shareError)
+		return shareError, shareError.temporary()
+	}
+
+	return json.Unmarshal(responseBody, response), false
 }
@@ -0,0 +1,279 @@
+package dexcom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+const (
+	testAccountId = "1e913fce-5a34-4d27-a991-b6cb3a3bd3d7"
+	testSessionId = "f6bb0d38-9c56-4f23-8f6e-1d5c2b8d2a8a"
+)
+
+// newFakeShare returns a fake Dexcom Share server. Its first readings request fails with an expired
+// session when expireSession is set.
+func newFakeShare(t *testing.T, applicationId string, expireSession bool) *httptest.Server {
+	logins := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+			return
+		}
+
+		switch r.URL.Path {
+		case AuthEndpoint:
+			if body["accountName"] != "username" || body["applicationId"] != applicationId {
+				t.Errorf("unexpected auth request %+v", body)
+			}
+			fmt.Fprintf(w, "%q", testAccountId)
+		case LoginEndpoint:
+			if body["applicationId"] != applicationId {
+				t.Errorf("unexpected login request %+v", body)
+			}
+			if body["accountId"] != testAccountId {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"Code": "AccountPasswordInvalid", "Message": "Invalid account"}`)
+				return
+			}
+			logins++
+			fmt.Fprintf(w, "%q", testSessionId)
+		case GlucoseReadingsEndpoint:
+			if body["sessionId"] != testSessionId {
+				t.Errorf("unexpected session %v", body["sessionId"])
+			}
+			if expireSession && logins == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"Code": "SessionIdNotFound", "Message": "Session ID not found"}`)
+				return
+			}
+			fmt.Fprintf(w, `[{"WT": "Date(%d)", "Value": 120, "Trend": "SingleUp"}]`, time.Now().UnixMilli())
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+}
+
+func TestClientRegion(t *testing.T) {
+	for _, region := range []Region{"", US, OUS, JP} {
+		applicationId := RegionToApplicationIdMap[region]
+		if region == "" {
+			applicationId = ApplicationId
+		}
+		server := newFakeShare(t, applicationId, false)
+
+		client, err := NewClient(ClientInput{Username: "username", Password: "password", Region: region, BaseUrl: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reading, err := client.GetCurrentBloodGlucoseReading(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.AccountId != testAccountId || client.SessionId != testSessionId {
+			t.Errorf("%s: unexpected account %s and session %s", region, client.AccountId, client.SessionId)
+		}
+		if reading.Value != 120 || reading.Trend != "SingleUp" {
+			t.Errorf("%s: unexpected reading %+v", region, reading)
+		}
+		server.Close()
+	}
+
+	if _, err := NewClient(ClientInput{Region: "eu"}); err == nil {
+		t.Errorf("expected error for unknown region")
+	}
+}
+
+func TestClientRetrySession(t *testing.T) {
+	server := newFakeShare(t, ApplicationId, true)
+	defer server.Close()
+
+	client, err := NewClient(ClientInput{Username: "username", Password: "password", BaseUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading, err := client.GetCurrentBloodGlucoseReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Value != 120 {
+		t.Errorf("expected 120, got %d", reading.Value)
+	}
+}
+
+func TestClientRetryServerError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintf(w, "%q", testAccountId)
+	}))
+	defer server.Close()
+
+	client := &Client{BaseUrl: server.URL, HTTPClient: server.Client(), MaxRetries: 2, RetryBackoff: time.Millisecond}
+	err := client.RetrieveAccountId(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = -10
+	err = client.RetrieveAccountId(context.Background())
+	var shareError *ShareError
+	if !errors.As(err, &shareError) || shareError.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected bad gateway, got %v", err)
+	}
+	if attempts != -7 {
+		t.Errorf("expected 3 attempts, got %d", attempts+10)
+	}
+}
+
+func TestClientRetryCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client := &Client{BaseUrl: server.URL, HTTPClient: server.Client(), MaxRetries: 5, RetryBackoff: time.Hour}
+	err := client.RetrieveAccountId(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected error
+	}{
+		{"password invalid", http.StatusInternalServerError, `{"Code": "AccountPasswordInvalid", "Message": "Invalid password"}`, ErrAuthFailed},
+		{"account not found", http.StatusInternalServerError, `{"Code": "SSO_AuthenticateAccountNotFound"}`, ErrAuthFailed},
+		{"default account id", http.StatusOK, `"00000000-0000-0000-0000-000000000000"`, ErrAuthFailed},
+		{"max attempts", http.StatusInternalServerError, `{"Code": "SSO_AuthenticateMaxAttemptsExceeed"}`, ErrRateLimited},
+		{"too many requests", http.StatusTooManyRequests, ``, ErrRateLimited},
+		{"session expired", http.StatusInternalServerError, `{"Code": "SessionNotValid"}`, ErrSessionExpired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			}))
+			defer server.Close()
+
+			client := &Client{BaseUrl: server.URL, HTTPClient: server.Client(), MaxRetries: 2, RetryBackoff: time.Millisecond}
+			err := client.RetrieveAccountId(context.Background())
+			if !errors.Is(err, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+			if attempts != 1 {
+				t.Errorf("expected 1 attempt, got %d", attempts)
+			}
+		})
+	}
+}
+
+func TestClientNoReadings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	client := &Client{BaseUrl: server.URL, HTTPClient: server.Client(), SessionId: testSessionId}
+	_, err := client.GetCurrentBloodGlucoseReading(context.Background())
+	if !errors.Is(err, ErrNoReadings) {
+		t.Errorf("expected no readings, got %v", err)
+	}
+}
+
+func TestClientLazyLogin(t *testing.T) {
+	client, err := NewClient(ClientInput{Username: "username", Password: "password", BaseUrl: "http://127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Status().State != cgm.NotConnected {
+		t.Errorf("expected %s, got %s", cgm.NotConnected, client.Status().State)
+	}
+
+	client.MaxRetries = 0
+	_, err = client.GetCurrentBloodGlucoseReading(context.Background())
+	if err == nil {
+		t.Fatal("expected error while Dexcom Share is down")
+	}
+	status := client.Status()
+	if status.State != cgm.Unavailable || status.Error == "" || status.LastChecked == nil || status.LastConnected != nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	server := newFakeShare(t, ApplicationId, false)
+	defer server.Close()
+	client.BaseUrl = server.URL
+	_, err = client.GetCurrentBloodGlucoseReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = client.Status()
+	if status.State != cgm.Connected || status.Error != "" || status.LastConnected == nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestClientRetryAccountId(t *testing.T) {
+	server := newFakeShare(t, ApplicationId, false)
+	defer server.Close()
+
+	client, err := NewClient(ClientInput{Username: "username", Password: "password", BaseUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A stale Account ID is rejected on login, so both IDs are retrieved again
+	client.AccountId = "5d4c5f3f-4b8e-4f4e-9d0e-7c2f9f9e0a11"
+
+	reading, err := client.GetCurrentBloodGlucoseReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reading.Value != 120 || client.AccountId != testAccountId || client.SessionId != testSessionId {
+		t.Errorf("unexpected reading %+v, account %s, and session %s", reading, client.AccountId, client.SessionId)
+	}
+}
+
+func TestClientStatusAuthFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"Code": "AccountPasswordInvalid"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(ClientInput{BaseUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetCurrentBloodGlucoseReading(context.Background())
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("expected auth failed, got %v", err)
+	}
+	if client.Status().State != cgm.AuthFailed {
+		t.Errorf("expected %s, got %s", cgm.AuthFailed, client.Status().State)
+	}
+}
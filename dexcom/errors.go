@@ -0,0 +1,56 @@
+package dexcom
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// The username or password is wrong, or the account can not share
+	ErrAuthFailed = errors.New("dexcom share authentication failed")
+	// The session expired, log in again
+	ErrSessionExpired = errors.New("dexcom share session expired")
+	// Dexcom Share has no readings
+	ErrNoReadings = errors.New("no readings available from Dexcom Share")
+	// Too many requests (or login attempts), try again later
+	ErrRateLimited = errors.New("dexcom share rate limited")
+)
+
+// A ShareError is an error response from Dexcom Share
+type ShareError struct {
+	StatusCode int
+	Code       string `json:"Code"`
+	Message    string `json:"Message"`
+}
+
+func (e *ShareError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("dexcom share responded %d", e.StatusCode)
+	}
+	return fmt.Sprintf("dexcom share responded %d, %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Dexcom Share error codes, and the error each is
+var CodeToErrorMap = map[string]error{
+	"SessionIdNotFound":                  ErrSessionExpired,
+	"SessionNotValid":                    ErrSessionExpired,
+	"AccountPasswordInvalid":             ErrAuthFailed,
+	"SSO_AuthenticateAccountNotFound":    ErrAuthFailed,
+	"SSO_AuthenticatePasswordInvalid":    ErrAuthFailed,
+	"SSO_InternalError":                  ErrAuthFailed,
+	"SSO_AuthenticateMaxAttemptsExceeed": ErrRateLimited,
+}
+
+// Unwrap returns the error for the code, so a ShareError can be checked with errors.Is
+func (e *ShareError) Unwrap() error {
+	if e.StatusCode == http.StatusTooManyRequests {
+		return ErrRateLimited
+	}
+	return CodeToErrorMap[e.Code]
+}
+
+// temporary reports whether the request may succeed if retried
+func (e *ShareError) temporary() bool {
+	return e.StatusCode >= 500 && e.Unwrap() == nil
+}
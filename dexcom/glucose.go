@@ -1,12 +1,15 @@
 package dexcom
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
-	"net/http"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
 )
 
 type CurrentBloodGlucoseReadingRequest struct {
@@ -15,105 +18,126 @@ type CurrentBloodGlucoseReadingRequest struct {
 	MaxCount  int    `json:"maxCount"`
 }
 
-type CurrentBloodGlucoseReadingError struct {
-	Code    string `json:"Code"`
-	Message string `json:"Message"`
-}
-
-type CurrentBloodGlucoseReading struct {
+type BloodGlucoseReading struct {
 	Value int    `json:"Value"`
 	Trend string `json:"Trend"`
+	// Time of the reading, for example "Date(1691455258000)"
+	WT string `json:"WT"`
 }
 
-// https://www.dexcom.com/all-access/dexcom-cgm-explained/trend-arrow-and-treatment-decisions
-var TrendToDeltaMap = map[string]int{
-	"DoubleUp":       45,
-	"SingleUp":       30,
-	"FortyFiveUp":    15,
-	"Flat":           0,
-	"FortyFiveDown":  -15,
-	"SingleDown":     -30,
-	"DoubleDown":     -45,
-	"None":           0,
-	"NotComputable":  0,
-	"RateOutOfRange": 0,
+// Time parses the time of the reading from WT
+func (r BloodGlucoseReading) Time() (time.Time, error) {
+	ms, ok := strings.CutPrefix(r.WT, "Date(")
+	if !ok || !strings.HasSuffix(ms, ")") {
+		return time.Time{}, fmt.Errorf("unexpected reading time %q", r.WT)
+	}
+	ms = strings.TrimSuffix(ms, ")")
+	// Drop the time zone offset, if any (the milliseconds are since the Unix epoch)
+	if i := strings.IndexAny(ms, "+-"); i > 0 {
+		ms = ms[:i]
+	}
+	millis, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected reading time %q", r.WT)
+	}
+	return time.UnixMilli(millis), nil
 }
 
+type CurrentBloodGlucoseReading struct {
+	BloodGlucoseReading
+	// The most recent readings (up to MaxRecentReadings), most recent (this reading) first
+	RecentReadings []BloodGlucoseReading
+}
+
+const (
+	// Minutes to look back for the most recent reading, which may be stale
+	LookbackMinutes = 24 * 60
+	// Readings are every 5 minutes, so at most this many are recent
+	MaxRecentReadings = 5
+)
+
+// Trend arrows that do not give a trend
+var TrendNotComputableList = []string{"", "None", "NotComputable", "RateOutOfRange"}
+
+// Get15MinDeltaFromTrend returns the change in Blood Glucose over 15 minutes of the trend arrow, 0 when it
+// does not give a trend
 func (c CurrentBloodGlucoseReading) Get15MinDeltaFromTrend() int {
-	return TrendToDeltaMap[c.Trend]
+	return int(cgm.TrendArrowToDeltaMap[cgm.DirectionToTrendArrowMap[c.Trend]])
 }
 
-func (c *Client) GetCurrentBloodGlucoseReading() (*CurrentBloodGlucoseReading, error) {
-	reading, err := c.getCurrentBloodGlucoseReading()
-	if err != nil {
-		if strings.Contains(err.Error(), "retry session") {
-			c.RetrieveSessionId()
-			reading, err = c.getCurrentBloodGlucoseReading()
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, err
+// Get15MinDelta projects the change in Blood Glucose over the next 15 minutes from the rate of
+// change fitted to the recent readings, or from the trend arrow when there are too few of them
+func (c CurrentBloodGlucoseReading) Get15MinDelta() (float32, cgm.TrendMethod) {
+	var points []cgm.Point
+	for _, reading := range c.RecentReadings {
+		t, err := reading.Time()
+		if err != nil {
+			continue
 		}
+		points = append(points, cgm.Point{Time: t, ValueInMgDl: float32(reading.Value)})
 	}
-	return reading, nil
+	return cgm.Trend(points, float32(c.Get15MinDeltaFromTrend()), !slices.Contains(TrendNotComputableList, c.Trend))
 }
 
-func (c *Client) getCurrentBloodGlucoseReading() (*CurrentBloodGlucoseReading, error) {
-	glucoseRequest := CurrentBloodGlucoseReadingRequest{
-		SessionId: c.SessionId,
-		Minutes:   10,
-		MaxCount:  1,
-	}
-
-	requestBody, err := json.Marshal(&glucoseRequest)
+// GetCurrentBloodGlucoseReading returns the most recent reading (which may be stale), logging in
+// first if needed, and again if the session expired
+func (c *Client) GetCurrentBloodGlucoseReading(ctx context.Context) (*CurrentBloodGlucoseReading, error) {
+	reading, err := c.loginAndGetCurrentBloodGlucoseReading(ctx)
+	c.recordStatus(err)
 	if err != nil {
 		return nil, err
 	}
+	return reading, nil
+}
 
-	response, err := http.Post(BaseUrl+GlucoseReadingsEndpoint, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode > 300 {
-		responseBody, err := io.ReadAll(response.Body)
+func (c *Client) loginAndGetCurrentBloodGlucoseReading(ctx context.Context) (*CurrentBloodGlucoseReading, error) {
+	if c.SessionId == "" {
+		err := c.Login(ctx)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		var errorResponse CurrentBloodGlucoseReadingError
-		err = json.Unmarshal(responseBody, &errorResponse)
+	reading, err := c.getCurrentBloodGlucoseReading(ctx)
+	if errors.Is(err, ErrSessionExpired) {
+		c.SessionId = ""
+		err = c.Login(ctx)
 		if err != nil {
 			return nil, err
 		}
+		reading, err = c.getCurrentBloodGlucoseReading(ctx)
+	}
+	return reading, err
+}
 
-		if errorResponse.Code == "SessionIdNotFound" || errorResponse.Code == "SessionNotValid" {
-			return nil, errors.New("retry session")
-		} else {
-			return nil, errors.New(errorResponse.Code + ": " + errorResponse.Message)
-		}
+func (c *Client) getCurrentBloodGlucoseReading(ctx context.Context) (*CurrentBloodGlucoseReading, error) {
+	glucoseRequest := CurrentBloodGlucoseReadingRequest{
+		SessionId: c.SessionId,
+		Minutes:   LookbackMinutes,
+		MaxCount:  MaxRecentReadings,
 	}
 
-	responseBody, err := io.ReadAll(response.Body)
+	var glucoseResponse []BloodGlucoseReading
+	err := c.post(ctx, GlucoseReadingsEndpoint, &glucoseRequest, &glucoseResponse)
 	if err != nil {
 		return nil, err
 	}
 
-	var glucoseResponse []*CurrentBloodGlucoseReading
-	err = json.Unmarshal(responseBody, &glucoseResponse)
-	if err != nil {
-		return nil, err
+	if len(glucoseResponse) == 0 {
+		return nil, ErrNoReadings
 	}
 
-	if len(glucoseResponse) == 0 && string(responseBody) == "[]" {
-		return nil, errors.New("cannot talk to Dexcom Share")
+	if len(glucoseResponse) > MaxRecentReadings {
+		return nil, fmt.Errorf("expected at most %d readings, got %d", MaxRecentReadings, len(glucoseResponse))
 	}
 
-	if len(glucoseResponse) != 1 {
-		return nil, errors.New(string(responseBody))
+	// Readings are most recent first, the most recent is the current one (even if it is stale)
+	if _, err := glucoseResponse[0].Time(); err != nil {
+		return nil, err
 	}
 
-	return glucoseResponse[0], nil
+	return &CurrentBloodGlucoseReading{
+		BloodGlucoseReading: glucoseResponse[0],
+		RecentReadings:      glucoseResponse,
+	}, nil
 }
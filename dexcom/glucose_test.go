@@ -0,0 +1,64 @@
+package dexcom
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+func readings(start time.Time, values ...int) []BloodGlucoseReading {
+	var readings []BloodGlucoseReading
+	for i, value := range values {
+		t := start.Add(-time.Duration(i) * 5 * time.Minute)
+		readings = append(readings, BloodGlucoseReading{
+			Value: value,
+			Trend: "Flat",
+			WT:    fmt.Sprintf("Date(%d)", t.UnixMilli()),
+		})
+	}
+	return readings
+}
+
+func TestBloodGlucoseReadingTime(t *testing.T) {
+	for _, wt := range []string{"Date(1691455258000)", "Date(1691455258000-0400)"} {
+		reading := BloodGlucoseReading{WT: wt}
+		readingTime, err := reading.Time()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !readingTime.Equal(time.UnixMilli(1691455258000)) {
+			t.Errorf("expected %s, got %s", time.UnixMilli(1691455258000), readingTime)
+		}
+	}
+
+	if _, err := (BloodGlucoseReading{WT: "1691455258000"}).Time(); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestGet15MinDelta(t *testing.T) {
+	now := time.Now()
+
+	// Rising 2 mg/dL per minute, most recent first
+	recent := readings(now, 140, 130, 120, 110)
+	current := CurrentBloodGlucoseReading{BloodGlucoseReading: recent[0], RecentReadings: recent}
+	delta, method := current.Get15MinDelta()
+	if method != cgm.FittedTrend {
+		t.Errorf("expected %s, got %s", cgm.FittedTrend, method)
+	}
+	if delta != 30 {
+		t.Errorf("expected 30, got %f", delta)
+	}
+
+	// Too few readings to fit a trend, so the (Flat) arrow is used
+	current.RecentReadings = recent[:2]
+	delta, method = current.Get15MinDelta()
+	if method != cgm.ArrowTrend {
+		t.Errorf("expected %s, got %s", cgm.ArrowTrend, method)
+	}
+	if delta != 0 {
+		t.Errorf("expected 0, got %f", delta)
+	}
+}
@@ -0,0 +1,50 @@
+package dexcom
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+// CurrentReading implements cgm.Source for Dexcom Share
+func (c *Client) CurrentReading(ctx context.Context) (cgm.Reading, error) {
+	readings, err := c.RecentReadings(ctx)
+	if err != nil {
+		return cgm.Reading{}, err
+	}
+	return readings[0], nil
+}
+
+// RecentReadings implements cgm.RecentSource, with the trend of each reading from the readings up to it.
+// Readings (other than the current one) with a time that can't be parsed are left out.
+func (c *Client) RecentReadings(ctx context.Context) ([]cgm.Reading, error) {
+	current, err := c.GetCurrentBloodGlucoseReading(ctx)
+	if errors.Is(err, ErrNoReadings) {
+		return nil, cgm.ErrNoReadings
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []cgm.Reading
+	for i, recent := range current.RecentReadings {
+		readingTime, err := recent.Time()
+		if err != nil {
+			continue
+		}
+		reading := CurrentBloodGlucoseReading{BloodGlucoseReading: recent, RecentReadings: current.RecentReadings[i:]}
+		trend, trendMethod := reading.Get15MinDelta()
+		readings = append(readings, cgm.Reading{
+			Time:                readingTime,
+			ValueInMgDl:         float32(recent.Value),
+			TrendInMgDlIn15Mins: trend,
+			TrendMethod:         trendMethod,
+		})
+	}
+	return readings, nil
+}
+
+func (c *Client) Name() string {
+	return "dexcom"
+}
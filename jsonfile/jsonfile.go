@@ -6,6 +6,8 @@ package jsonfile
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -77,6 +79,15 @@ func (p *JSONFile[Data]) Read(fn func(data *Data)) {
 	fn(p.data)
 }
 
+// ETag returns a quoted hash of the file contents, suitable for an HTTP ETag
+// header. It changes whenever Write changes the data.
+func (p *JSONFile[Data]) ETag() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	sum := sha256.Sum256(p.bytes)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
 // Write calls fn with a copy of the data, then writes the changes to the file.
 // If fn returns an error, Write does not change the file and returns the error.
 func (p *JSONFile[Data]) Write(fn func(*Data) error) error {
@@ -0,0 +1,254 @@
+package librelinkup
+
+// Reads the latest glucose measurement shared through LibreLinkUp, see
+// https://github.com/DiaKEM/libre-link-up-api-client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+const (
+	BaseUrl             = "https://api.libreview.io"
+	RegionalBaseUrl     = "https://api-%s.libreview.io"
+	LoginEndpoint       = "/llu/auth/login"
+	ConnectionsEndpoint = "/llu/connections"
+	Product             = "llu.android"
+	Version             = "4.12.0"
+	// Layout of FactoryTimestamp (UTC)
+	TimestampLayout = "1/2/2006 3:04:05 PM"
+)
+
+type Client struct {
+	Email    string
+	Password string
+	// API URL, which changes to the account's region after login
+	BaseUrl string
+	// API URL of a region, formatted with the region (defaults to RegionalBaseUrl)
+	RegionalBaseUrl string
+	Token           string
+	// SHA-256 of the user ID, sent with every request after login
+	AccountId  string
+	HTTPClient *http.Client
+}
+
+type ClientInput struct {
+	Email    string
+	Password string
+	// Defaults to BaseUrl
+	BaseUrl string
+	// Defaults to a client with a Timeout of cgm.DefaultTimeout
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client, which logs in on its first request
+func NewClient(input ClientInput) (*Client, error) {
+	if input.Email == "" || input.Password == "" {
+		return nil, errors.New("librelinkup email and password required")
+	}
+
+	client := &Client{
+		Email:      input.Email,
+		Password:   input.Password,
+		BaseUrl:    input.BaseUrl,
+		HTTPClient: input.HTTPClient,
+	}
+	if client.BaseUrl == "" {
+		client.BaseUrl = BaseUrl
+	}
+	if client.HTTPClient == nil {
+		client.HTTPClient = &http.Client{Timeout: cgm.DefaultTimeout}
+	}
+
+	return client, nil
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	Status int `json:"status"`
+	Data   struct {
+		// Set (with Region) when the account is in another region
+		Redirect bool   `json:"redirect"`
+		Region   string `json:"region"`
+		User     struct {
+			Id string `json:"id"`
+		} `json:"user"`
+		AuthTicket struct {
+			Token string `json:"token"`
+		} `json:"authTicket"`
+	} `json:"data"`
+}
+
+// Login retrieves an auth token, following the redirect to the account's region
+func (c *Client) Login(ctx context.Context) error {
+	return c.login(ctx, true)
+}
+
+// login retrieves an auth token, following a redirect only when followRedirect is set, so a region that
+// redirects again fails instead of redirecting forever
+func (c *Client) login(ctx context.Context, followRedirect bool) error {
+	requestBody, err := json.Marshal(&LoginRequest{Email: c.Email, Password: c.Password})
+	if err != nil {
+		return err
+	}
+
+	var loginResponse LoginResponse
+	err = c.do(ctx, http.MethodPost, LoginEndpoint, requestBody, &loginResponse)
+	if err != nil {
+		return err
+	}
+
+	if loginResponse.Data.Redirect {
+		if !followRedirect {
+			return errors.New("librelinkup redirected more than once")
+		}
+		if loginResponse.Data.Region == "" {
+			return errors.New("librelinkup redirected without a region")
+		}
+		regionalBaseUrl := c.RegionalBaseUrl
+		if regionalBaseUrl == "" {
+			regionalBaseUrl = RegionalBaseUrl
+		}
+		c.BaseUrl = fmt.Sprintf(regionalBaseUrl, loginResponse.Data.Region)
+		return c.login(ctx, false)
+	}
+
+	if loginResponse.Status != 0 || loginResponse.Data.AuthTicket.Token == "" {
+		return fmt.Errorf("librelinkup login failed with status %d", loginResponse.Status)
+	}
+	c.Token = loginResponse.Data.AuthTicket.Token
+	accountId := sha256.Sum256([]byte(loginResponse.Data.User.Id))
+	c.AccountId = hex.EncodeToString(accountId[:])
+
+	return nil
+}
+
+type GlucoseMeasurement struct {
+	// Time of the measurement in UTC, see TimestampLayout
+	FactoryTimestamp string  `json:"FactoryTimestamp"`
+	ValueInMgPerDl   float32 `json:"ValueInMgPerDl"`
+	// 1 (falling quickly) to 5 (rising quickly), 0 when not computable
+	TrendArrow int `json:"TrendArrow"`
+}
+
+type Connection struct {
+	PatientId          string             `json:"patientId"`
+	GlucoseMeasurement GlucoseMeasurement `json:"glucoseMeasurement"`
+}
+
+type ConnectionsResponse struct {
+	Status int          `json:"status"`
+	Data   []Connection `json:"data"`
+}
+
+// LibreLinkUp trend arrows, which have no equivalent of the quickly rising and falling Dexcom arrows
+var TrendArrowMap = map[int]cgm.TrendArrow{
+	1: cgm.Falling,
+	2: cgm.FallingSlowly,
+	3: cgm.Flat,
+	4: cgm.RisingSlowly,
+	5: cgm.Rising,
+}
+
+// GetConnections returns the patients sharing with the account, with their latest measurement
+func (c *Client) GetConnections(ctx context.Context) ([]Connection, error) {
+	var connectionsResponse ConnectionsResponse
+	err := c.do(ctx, http.MethodGet, ConnectionsEndpoint, nil, &connectionsResponse)
+	if err != nil {
+		return nil, err
+	}
+	if connectionsResponse.Status != 0 {
+		return nil, fmt.Errorf("librelinkup connections failed with status %d", connectionsResponse.Status)
+	}
+	return connectionsResponse.Data, nil
+}
+
+// CurrentReading implements cgm.Source for the first patient sharing with the account. The
+// measurements shared are too far apart to fit a trend to, so the trend arrow is used.
+func (c *Client) CurrentReading(ctx context.Context) (cgm.Reading, error) {
+	if c.Token == "" {
+		err := c.Login(ctx)
+		if err != nil {
+			return cgm.Reading{}, err
+		}
+	}
+
+	connections, err := c.GetConnections(ctx)
+	if errors.Is(err, errUnauthorized) {
+		err = c.Login(ctx)
+		if err != nil {
+			return cgm.Reading{}, err
+		}
+		connections, err = c.GetConnections(ctx)
+	}
+	if err != nil {
+		return cgm.Reading{}, err
+	}
+	if len(connections) == 0 || connections[0].GlucoseMeasurement.FactoryTimestamp == "" {
+		return cgm.Reading{}, cgm.ErrNoReadings
+	}
+
+	measurement := connections[0].GlucoseMeasurement
+	readingTime, err := time.Parse(TimestampLayout, measurement.FactoryTimestamp)
+	if err != nil {
+		return cgm.Reading{}, err
+	}
+	arrow, ok := TrendArrowMap[measurement.TrendArrow]
+	arrowDelta := cgm.TrendArrowToDeltaMap[arrow]
+	trend, trendMethod := cgm.Trend(nil, arrowDelta, ok)
+
+	return cgm.Reading{
+		Time:                readingTime,
+		ValueInMgDl:         measurement.ValueInMgPerDl,
+		TrendInMgDlIn15Mins: trend,
+		TrendMethod:         trendMethod,
+	}, nil
+}
+
+func (c *Client) Name() string {
+	return "librelinkup"
+}
+
+var errUnauthorized = errors.New("librelinkup unauthorized")
+
+func (c *Client) do(ctx context.Context, method string, endpoint string, requestBody []byte, responseData any) error {
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseUrl+endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("product", Product)
+	request.Header.Set("version", Version)
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+		request.Header.Set("Account-Id", c.AccountId)
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		return errUnauthorized
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("librelinkup responded %s", response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(responseData)
+}
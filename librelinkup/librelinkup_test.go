@@ -0,0 +1,131 @@
+package librelinkup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+func TestCurrentReading(t *testing.T) {
+	logins := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("product") != Product || r.Header.Get("version") != Version {
+			t.Errorf("missing product and version headers")
+		}
+
+		switch r.URL.Path {
+		case LoginEndpoint:
+			var login LoginRequest
+			if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login.Email != "me@example.com" {
+				t.Errorf("unexpected login %+v, %v", login, err)
+			}
+			logins++
+			fmt.Fprint(w, `{"status": 0, "data": {"user": {"id": "user-id"}, "authTicket": {"token": "token"}}}`)
+		case ConnectionsEndpoint:
+			accountId := sha256.Sum256([]byte("user-id"))
+			if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Account-Id") != hex.EncodeToString(accountId[:]) {
+				t.Errorf("missing auth headers")
+			}
+			// The token expires after the first request
+			if logins == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"status": 0, "data": [{"patientId": "patient-id", "glucoseMeasurement": {
+				"FactoryTimestamp": "4/7/2025 3:04:05 PM", "ValueInMgPerDl": 142, "TrendArrow": 4
+			}}]}`)
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(ClientInput{Email: "me@example.com", Password: "password", BaseUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins != 0 {
+		t.Errorf("expected to log in on the first reading, got %d logins", logins)
+	}
+	reading, err := client.CurrentReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins != 2 {
+		t.Errorf("expected to log in again, got %d logins", logins)
+	}
+	if !reading.Time.Equal(time.Date(2025, 4, 7, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected time %s", reading.Time)
+	}
+	if reading.ValueInMgDl != 142 {
+		t.Errorf("expected 142, got %f", reading.ValueInMgDl)
+	}
+	if reading.TrendInMgDlIn15Mins != 15 || reading.TrendMethod != cgm.ArrowTrend {
+		t.Errorf("expected an arrow trend of 15, got %s %f", reading.TrendMethod, reading.TrendInMgDlIn15Mins)
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	regional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eu"+LoginEndpoint {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"status": 0, "data": {"user": {"id": "user-id"}, "authTicket": {"token": "regional-token"}}}`)
+	}))
+	defer regional.Close()
+	global := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": 0, "data": {"redirect": true, "region": "eu"}}`)
+	}))
+	defer global.Close()
+
+	client := &Client{
+		Email:           "me@example.com",
+		Password:        "password",
+		BaseUrl:         global.URL,
+		RegionalBaseUrl: regional.URL + "/%s",
+		HTTPClient:      http.DefaultClient,
+	}
+	err := client.Login(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token != "regional-token" {
+		t.Errorf("expected regional-token, got %s", client.Token)
+	}
+	if client.BaseUrl != regional.URL+"/eu" {
+		t.Errorf("expected %s, got %s", regional.URL+"/eu", client.BaseUrl)
+	}
+}
+
+func TestLoginRedirectLoop(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"status": 0, "data": {"redirect": true, "region": "eu"}}`)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		Email:           "me@example.com",
+		Password:        "password",
+		BaseUrl:         server.URL,
+		RegionalBaseUrl: server.URL + "/%s",
+		HTTPClient:      http.DefaultClient,
+	}
+	err := client.Login(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 login requests, got %d", requests)
+	}
+}
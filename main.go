@@ -1,24 +1,104 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
 
+	"github.com/kennedyjustin/BolusGPT/cgm"
+	"github.com/kennedyjustin/BolusGPT/dexcom"
+	"github.com/kennedyjustin/BolusGPT/librelinkup"
+	"github.com/kennedyjustin/BolusGPT/nightscout"
 	"github.com/kennedyjustin/BolusGPT/server"
 )
 
-const Filepath = "me.json"
+const (
+	Filepath        = "me.json"
+	LogbookFilepath = "logbook.json"
+	HistoryFilepath = "history.json"
+)
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	s, err := server.NewServer(server.ServerInput{
-		FilePath:       Filepath,
-		DexcomUsername: os.Getenv("DEXCOM_USERNAME"),
-		DexcomPassword: os.Getenv("DEXCOM_PASSWORD"),
-		BearerToken:    os.Getenv("BEARER_TOKEN"),
-	})
+
+	// With a user registry, each user has their own Bearer Token, files, and Glucose Source. Otherwise
+	// the single user is configured from the environment.
+	var input server.ServerInput
+	if usersFilepath := os.Getenv("USERS_FILE"); usersFilepath != "" {
+		users, err := loadUsers(usersFilepath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		input.Users = users
+	} else {
+		glucoseSource, err := newGlucoseSource(glucoseSourceConfigFromEnv())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		input = server.ServerInput{
+			FilePath:        Filepath,
+			LogbookFilePath: LogbookFilepath,
+			HistoryFilePath: HistoryFilepath,
+			GlucoseSource:   glucoseSource,
+			BearerToken:     os.Getenv("BEARER_TOKEN"),
+		}
+	}
+
+	s, err := server.NewServer(input)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	s.Start()
 }
+
+// GlucoseSourceConfig configures the CGM to read Blood Glucose from
+type GlucoseSourceConfig struct {
+	// "dexcom" (default), "nightscout", "librelinkup", or "manual"
+	GlucoseSource       string `json:"glucose_source"`
+	DexcomUsername      string `json:"dexcom_username"`
+	DexcomPassword      string `json:"dexcom_password"`
+	DexcomRegion        string `json:"dexcom_region"`
+	NightscoutUrl       string `json:"nightscout_url"`
+	NightscoutToken     string `json:"nightscout_token"`
+	LibreLinkUpEmail    string `json:"librelinkup_email"`
+	LibreLinkUpPassword string `json:"librelinkup_password"`
+}
+
+func glucoseSourceConfigFromEnv() GlucoseSourceConfig {
+	return GlucoseSourceConfig{
+		GlucoseSource:       os.Getenv("GLUCOSE_SOURCE"),
+		DexcomUsername:      os.Getenv("DEXCOM_USERNAME"),
+		DexcomPassword:      os.Getenv("DEXCOM_PASSWORD"),
+		DexcomRegion:        os.Getenv("DEXCOM_REGION"),
+		NightscoutUrl:       os.Getenv("NIGHTSCOUT_URL"),
+		NightscoutToken:     os.Getenv("NIGHTSCOUT_TOKEN"),
+		LibreLinkUpEmail:    os.Getenv("LIBRELINKUP_EMAIL"),
+		LibreLinkUpPassword: os.Getenv("LIBRELINKUP_PASSWORD"),
+	}
+}
+
+// newGlucoseSource configures the CGM to read Blood Glucose from (Dexcom Share by default)
+func newGlucoseSource(config GlucoseSourceConfig) (cgm.Source, error) {
+	switch config.GlucoseSource {
+	case "", "dexcom":
+		return dexcom.NewClient(dexcom.ClientInput{
+			Username: config.DexcomUsername,
+			Password: config.DexcomPassword,
+			Region:   dexcom.Region(config.DexcomRegion),
+		})
+	case "nightscout":
+		return nightscout.NewClient(nightscout.ClientInput{
+			BaseUrl: config.NightscoutUrl,
+			Token:   config.NightscoutToken,
+		})
+	case "librelinkup":
+		return librelinkup.NewClient(librelinkup.ClientInput{
+			Email:    config.LibreLinkUpEmail,
+			Password: config.LibreLinkUpPassword,
+		})
+	case "manual":
+		return cgm.ManualSource{}, nil
+	default:
+		return nil, errors.New("unknown glucose source: " + config.GlucoseSource)
+	}
+}
@@ -0,0 +1,136 @@
+package nightscout
+
+// Reads entries from the Nightscout REST API, see
+// https://github.com/nightscout/cgm-remote-monitor#rest-api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+const EntriesEndpoint = "/api/v1/entries/sgv.json"
+
+// Entries are every 5 minutes, so at most this many are recent
+const MaxRecentEntries = 5
+
+type Client struct {
+	// URL of the Nightscout site, for example "https://my-site.herokuapp.com"
+	BaseUrl string
+	// Access token with the readable role (optional for public sites)
+	Token      string
+	HTTPClient *http.Client
+}
+
+type ClientInput struct {
+	BaseUrl string
+	Token   string
+	// Defaults to a client with a Timeout of cgm.DefaultTimeout
+	HTTPClient *http.Client
+}
+
+func NewClient(input ClientInput) (*Client, error) {
+	if input.BaseUrl == "" {
+		return nil, errors.New("nightscout URL is required")
+	}
+	httpClient := input.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cgm.DefaultTimeout}
+	}
+	return &Client{
+		BaseUrl:    strings.TrimSuffix(input.BaseUrl, "/"),
+		Token:      input.Token,
+		HTTPClient: httpClient,
+	}, nil
+}
+
+type Entry struct {
+	// Blood Glucose in mg/dL
+	Sgv int `json:"sgv"`
+	// Milliseconds since the Unix epoch
+	Date int64 `json:"date"`
+	// Trend arrow, for example "Flat"
+	Direction string `json:"direction"`
+}
+
+func (c *Client) GetEntries(ctx context.Context, count int) ([]Entry, error) {
+	query := url.Values{"count": {strconv.Itoa(count)}}
+	if c.Token != "" {
+		query.Set("token", c.Token)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseUrl+EntriesEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nightscout responded %s", response.Status)
+	}
+
+	var entries []Entry
+	err = json.NewDecoder(response.Body).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CurrentReading implements cgm.Source for Nightscout
+func (c *Client) CurrentReading(ctx context.Context) (cgm.Reading, error) {
+	readings, err := c.RecentReadings(ctx)
+	if err != nil {
+		return cgm.Reading{}, err
+	}
+	return readings[0], nil
+}
+
+// RecentReadings implements cgm.RecentSource, with the trend of each reading from the readings up to it
+func (c *Client) RecentReadings(ctx context.Context) ([]cgm.Reading, error) {
+	entries, err := c.GetEntries(ctx, MaxRecentEntries)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, cgm.ErrNoReadings
+	}
+
+	// Entries are most recent first
+	var points []cgm.Point
+	for _, entry := range entries {
+		points = append(points, cgm.Point{Time: time.UnixMilli(entry.Date), ValueInMgDl: float32(entry.Sgv)})
+	}
+	var readings []cgm.Reading
+	for i, entry := range entries {
+		// Directions are named like the Dexcom trend arrows
+		arrow, ok := cgm.DirectionToTrendArrowMap[entry.Direction]
+		arrowDelta := cgm.TrendArrowToDeltaMap[arrow]
+		trend, trendMethod := cgm.Trend(points[i:], arrowDelta, ok)
+		readings = append(readings, cgm.Reading{
+			Time:                time.UnixMilli(entry.Date),
+			ValueInMgDl:         float32(entry.Sgv),
+			TrendInMgDlIn15Mins: trend,
+			TrendMethod:         trendMethod,
+		})
+	}
+	return readings, nil
+}
+
+func (c *Client) Name() string {
+	return "nightscout"
+}
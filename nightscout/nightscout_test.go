@@ -0,0 +1,132 @@
+package nightscout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+func TestCurrentReading(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != EntriesEndpoint {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("token") != "readable-token" {
+			t.Errorf("expected token, got %q", r.URL.Query().Get("token"))
+		}
+		fmt.Fprintf(w, `[
+			{"sgv": 130, "date": %d, "direction": "FortyFiveUp"},
+			{"sgv": 125, "date": %d, "direction": "FortyFiveUp"},
+			{"sgv": 120, "date": %d, "direction": "Flat"}
+		]`, now.UnixMilli(), now.Add(-5*time.Minute).UnixMilli(), now.Add(-10*time.Minute).UnixMilli())
+	}))
+	defer server.Close()
+
+	client, err := NewClient(ClientInput{BaseUrl: server.URL + "/", Token: "readable-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading, err := client.CurrentReading(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reading.Time.Equal(now) {
+		t.Errorf("expected %s, got %s", now, reading.Time)
+	}
+	if reading.ValueInMgDl != 130 {
+		t.Errorf("expected 130, got %f", reading.ValueInMgDl)
+	}
+	if reading.TrendInMgDlIn15Mins != 15 || reading.TrendMethod != cgm.FittedTrend {
+		t.Errorf("expected a fitted trend of 15, got %s %f", reading.TrendMethod, reading.TrendInMgDlIn15Mins)
+	}
+}
+
+func TestRecentReadings(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[
+			{"sgv": 130, "date": %d, "direction": "FortyFiveUp"},
+			{"sgv": 125, "date": %d, "direction": "FortyFiveUp"},
+			{"sgv": 120, "date": %d, "direction": "Flat"}
+		]`, now.UnixMilli(), now.Add(-5*time.Minute).UnixMilli(), now.Add(-10*time.Minute).UnixMilli())
+	}))
+	defer server.Close()
+
+	client, err := NewClient(ClientInput{BaseUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := client.RecentReadings(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 3 {
+		t.Fatalf("expected 3 readings, got %d", len(readings))
+	}
+	if readings[1].ValueInMgDl != 125 || !readings[1].Time.Equal(now.Add(-5*time.Minute)) {
+		t.Errorf("expected 125 five minutes ago, got %+v", readings[1])
+	}
+	// Too few readings up to the oldest to fit a trend to, so its arrow is used
+	if readings[2].TrendInMgDlIn15Mins != 0 || readings[2].TrendMethod != cgm.ArrowTrend {
+		t.Errorf("expected an arrow trend of 0, got %s %f", readings[2].TrendMethod, readings[2].TrendInMgDlIn15Mins)
+	}
+}
+
+func TestCurrentReadingArrow(t *testing.T) {
+	now := time.Now()
+	for direction, expectedMethod := range map[string]cgm.TrendMethod{
+		"SingleDown":     cgm.ArrowTrend,
+		"NOT COMPUTABLE": cgm.NoTrend,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `[{"sgv": 90, "date": %d, "direction": %q}]`, now.UnixMilli(), direction)
+		}))
+		client, err := NewClient(ClientInput{BaseUrl: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reading, err := client.CurrentReading(context.Background())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reading.TrendMethod != expectedMethod {
+			t.Errorf("%s: expected %s, got %s", direction, expectedMethod, reading.TrendMethod)
+		}
+	}
+}
+
+func TestCurrentReadingErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		body   string
+		noData bool
+	}{
+		{status: http.StatusOK, body: `[]`, noData: true},
+		{status: http.StatusUnauthorized, body: `{}`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			fmt.Fprint(w, tc.body)
+		}))
+		client, err := NewClient(ClientInput{BaseUrl: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.CurrentReading(context.Background())
+		server.Close()
+		if err == nil {
+			t.Fatalf("expected error for %d %s", tc.status, tc.body)
+		}
+		if errors.Is(err, cgm.ErrNoReadings) != tc.noData {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+var errBolusUnitsRequired = errors.New("positive 'units_of_insulin' required")
+
+type BolusInput struct {
+	Time           *string  `json:"time"`
+	UnitsOfInsulin *float32 `json:"units_of_insulin"`
+}
+
+func (s *Server) BolusHandlerPost(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	decoder := json.NewDecoder(request.Body)
+	input := BolusInput{}
+	err := decoder.Decode(&input)
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if input.UnitsOfInsulin == nil || *input.UnitsOfInsulin <= 0 {
+		http.Error(response, errBolusUnitsRequired.Error(), http.StatusBadRequest)
+		return
+	}
+	b := bolus.Bolus{
+		Time:           s.now(),
+		UnitsOfInsulin: *input.UnitsOfInsulin,
+	}
+	if input.Time != nil {
+		b.Time, err = parseTime(*input.Time, s.now())
+		if err != nil {
+			http.Error(response, "could not parse time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = u.logbook.Write(func(logbook *Logbook) error {
+		logbook.RecordBolus(b)
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(b)
+}
+
+// parseTime parses an RFC 3339 timestamp, or "now" for the given current time
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC 3339 or \"now\": " + err.Error())
+	}
+	return t, nil
+}
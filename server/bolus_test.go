@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+func TestBolusHandlerPost(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+
+	var b bolus.Bolus
+	response := ts.do(t, "alex", http.MethodPost, "/bolus", `{"units_of_insulin": 2, "time": "now"}`, &b)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if !b.Time.Equal(ts.now) || b.UnitsOfInsulin != 2 {
+		t.Errorf("unexpected bolus %+v", b)
+	}
+
+	// The Bolus is on board for the next Dose
+	var dose DoseOutput
+	ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, &dose)
+	if dose.Breakdown.InsulinOnBoardFactor != -2 {
+		t.Errorf("expected -2, got %f", dose.Breakdown.InsulinOnBoardFactor)
+	}
+
+	for _, body := range []string{`{}`, `{"units_of_insulin": -1}`, `{"units_of_insulin": 1, "time": "yesterday"}`} {
+		if response := ts.do(t, "alex", http.MethodPost, "/bolus", body, nil); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, response.Code)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/kennedyjustin/BolusGPT/bolus"
+	"github.com/kennedyjustin/BolusGPT/cgm"
 )
 
 type DoseInput struct {
@@ -13,72 +17,292 @@ type DoseInput struct {
 	GramsOfFiber        float32 `json:"grams_of_fiber"`
 	GramsOfSugarAlcohol float32 `json:"grams_of_sugar_alcohol"`
 	GramsOfProtein      float32 `json:"grams_of_protein"`
+	GramsOfFat          float32 `json:"grams_of_fat"`
 
 	MinutesOfExercise float32                 `json:"minutes_of_exercise"`
 	ExerciseIntensity bolus.ExerciseIntensity `json:"exercise_intensity"`
+
+	// Log the meal (its net carbs) so its Carbs On Board are counted in later doses
+	LogMeal bool `json:"log_meal"`
+	// Minutes for the logged meal to be absorbed (defaults to the user's default)
+	CarbAbsorptionTimeInMinutes float32 `json:"carb_absorption_time_in_minutes"`
+
+	// Blood Glucose entered manually (for example from a fingerstick) in the user's Glucose Unit, used
+	// instead of the Glucose Source
+	CurrentBloodGlucose *float32 `json:"current_blood_glucose"`
+	// Trend of the manually entered Blood Glucose, see cgm.TrendArrow (defaults to flat)
+	Trend *string `json:"trend"`
+}
+
+type DoseOutput struct {
+	// Recommendation ID, to confirm the Bolus taken for the Dose with
+	Id string
+	bolus.Dose
+	// Blood Glucose reading used for the correction, in the user's Glucose Unit
+	BloodGlucose BloodGlucoseOutput
+}
+
+type BloodGlucoseOutput struct {
+	// Current Blood Glucose
+	Value float32
+	// Expected change in Blood Glucose over the next 15 minutes
+	TrendIn15Mins float32
+	// Unit of Value and TrendIn15Mins
+	Unit bolus.GlucoseUnit
+	// Time of the CGM reading, unset when none is available
+	ReadingTime *time.Time
+	// Name of the Glucose Source, for example "dexcom", or "manual"
+	Source string
+	// Set when the Blood Glucose was entered manually with the request
+	Manual bool
 }
 
 func (s *Server) DoseHandler(response http.ResponseWriter, request *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var dose bolus.Dose
-	s.db.Read(func(data *Me) {
-		if data == nil {
-			http.Error(response, "please onboard", http.StatusNotFound)
-			return
-		}
+	u := userFrom(request.Context())
+
+	decoder := json.NewDecoder(request.Body)
+	input := DoseInput{}
+	err := decoder.Decode(&input)
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reading, readingErr := u.cgmReading(request.Context(), input)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
 
-		if data.InsulinToCarbRatio == 0 || data.InsulinSensitivityFactor == 0 || data.TargetBloodGlucoseLevelInMgDl == 0 {
-			http.Error(response, "'insulin_to_carb_ratio', 'insulin_sensitivity_factor', and 'target_blood_glucose_level_in_mg_dl' required", http.StatusNotFound)
-			return
+	var me Me
+	u.db.Read(func(data *Me) {
+		me = *data
+	})
+
+	now := s.now()
+	doseInput, bloodGlucose, err := u.newDoseInput(me, input, reading, readingErr, now)
+	if err != nil {
+		writeDoseError(response, err)
+		return
+	}
+
+	dose, err := bolus.GetDose(doseInput)
+	if err != nil {
+		writeDoseError(response, validationErrorsInGlucoseUnit(err, me.glucoseUnit()))
+		return
+	}
+
+	// A Dose taken but not confirmed is missing from Insulin On Board
+	var unconfirmed []DoseRecord
+	u.logbook.Read(func(logbook *Logbook) {
+		unconfirmed = logbook.UnconfirmedDosesSince(now.Add(-doseInput.InsulinOnBoardInput.InsulinModel.Duration()))
+	})
+	if len(unconfirmed) > 0 {
+		dose.Warnings = append(dose.Warnings, bolus.Warning{
+			Code:    bolus.UnconfirmedDose,
+			Message: "a recent dose was not confirmed, if it was taken confirm it so it counts as insulin on board",
+		})
+	}
+
+	settingsVersion, err := u.currentSettingsVersion(now, me)
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	output := DoseOutput{
+		Id:           uuid.NewString(),
+		Dose:         dose,
+		BloodGlucose: bloodGlucose,
+	}
+	err = u.logbook.Write(func(logbook *Logbook) error {
+		logbook.RecordDose(DoseRecord{
+			Id:              output.Id,
+			Time:            now,
+			Input:           input,
+			SettingsVersion: &settingsVersion,
+			Output:          output,
+		})
+		if input.LogMeal && dose.Breakdown.NetGramsOfCarbs > 0 {
+			logbook.RecordMeal(bolus.Meal{
+				Time:                    now,
+				GramsOfCarbs:            dose.Breakdown.NetGramsOfCarbs,
+				AbsorptionTimeInMinutes: input.carbAbsorptionTimeInMinutes(me),
+			})
 		}
+		logbook.Prune(now.Add(-eventRetention(doseInput.InsulinOnBoardInput.InsulinModel)))
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		decoder := json.NewDecoder(request.Body)
-		input := DoseInput{}
-		err := decoder.Decode(&input)
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(output)
+}
+
+// carbAbsorptionTimeInMinutes returns the absorption time of the meal, or the user's default
+func (input DoseInput) carbAbsorptionTimeInMinutes(me Me) float32 {
+	if input.CarbAbsorptionTimeInMinutes == 0 {
+		return me.DefaultCarbAbsorptionTimeInMinutes
+	}
+	return input.CarbAbsorptionTimeInMinutes
+}
+
+// manualReading returns the Blood Glucose entered manually with the request
+func (input DoseInput) manualReading(me Me, now time.Time) (cgm.Reading, error) {
+	var errs bolus.ValidationErrors
+	if *input.CurrentBloodGlucose <= 0 {
+		errs = append(errs, &bolus.ValidationError{Field: "current_blood_glucose", Message: "must be positive"})
+	}
+	trend := cgm.Flat
+	if input.Trend != nil {
+		trend = cgm.TrendArrow(*input.Trend)
+	}
+	delta, ok := cgm.TrendArrowToDeltaMap[trend]
+	if !ok {
+		errs = append(errs, &bolus.ValidationError{
+			Field:   "trend",
+			Message: "must be one of rising_quickly, rising, rising_slowly, flat, falling_slowly, falling, or falling_quickly",
+		})
+	}
+	if len(errs) > 0 {
+		return cgm.Reading{}, errs
+	}
+
+	return cgm.Reading{
+		Time:                now,
+		ValueInMgDl:         me.glucoseUnit().ToMgDl(*input.CurrentBloodGlucose),
+		TrendInMgDlIn15Mins: delta,
+		TrendMethod:         cgm.ManualTrend,
+	}, nil
+}
+
+// Time to wait for the Glucose Source, after which the Dose is calculated without a CGM reading
+const GlucoseSourceTimeout = 20 * time.Second
+
+// cgmReading returns the current reading of the user's Glucose Source, unless the Blood Glucose was entered
+// manually with the request. The Source is only polled when the last reading is too old to correct with.
+// It is read before locking u.mu, so a slow Glucose Source does not hold up the user's other requests.
+func (u *user) cgmReading(ctx context.Context, input DoseInput) (cgm.Reading, error) {
+	if input.CurrentBloodGlucose != nil {
+		return cgm.Reading{}, nil
+	}
+	var maxReadingAge time.Duration
+	u.db.Read(func(me *Me) {
+		maxReadingAge = me.maxReadingAge()
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, GlucoseSourceTimeout)
+	defer cancel()
+	return u.glucosePoller.CurrentReadingWithin(ctx, maxReadingAge)
+}
+
+// newDoseInput combines the request with the user's settings, current Blood Glucose (the CGM reading and
+// its error from cgmReading, unless it was entered manually), and the Logbook. Errors are either
+// bolus.ValidationErrors, or unexpected.
+func (u *user) newDoseInput(me Me, input DoseInput, cgmReading cgm.Reading, cgmErr error, now time.Time) (bolus.DoseInput, BloodGlucoseOutput, error) {
+	location, err := me.Location()
+	if err != nil {
+		return bolus.DoseInput{}, BloodGlucoseOutput{}, err
+	}
+
+	insulinModel, err := me.InsulinModel()
+	if err != nil {
+		return bolus.DoseInput{}, BloodGlucoseOutput{}, err
+	}
+
+	carbAbsorptionTimeInMinutes := input.carbAbsorptionTimeInMinutes(me)
+	if carbAbsorptionTimeInMinutes < 0 || carbAbsorptionTimeInMinutes > float32(bolus.MaxCarbAbsorptionTime.Minutes()) {
+		return bolus.DoseInput{}, BloodGlucoseOutput{}, bolus.ValidationErrors{{
+			Field:   "carb_absorption_time_in_minutes",
+			Message: "must be between 0 and 480",
+		}}
+	}
+
+	// A stale, unavailable, or trendless reading is not corrected with
+	correction := bolus.CorrectionInput{
+		TargetBloodGlucoseLevelInMgDl: me.TargetBloodGlucoseLevelInMgDl,
+		InsulinSensitivityFactor:      me.InsulinSensitivityFactor,
+	}
+	bloodGlucose := BloodGlucoseOutput{Unit: me.glucoseUnit(), Source: u.glucoseSource.Name()}
+	var reading cgm.Reading
+	if input.CurrentBloodGlucose != nil {
+		reading, err = input.manualReading(me, now)
 		if err != nil {
-			log.Println(err)
-			http.Error(response, err.Error(), http.StatusInternalServerError)
-			return
+			return bolus.DoseInput{}, BloodGlucoseOutput{}, err
 		}
+		bloodGlucose.Source = cgm.ManualSource{}.Name()
+		bloodGlucose.Manual = true
+	} else {
+		reading, err = cgmReading, cgmErr
+	}
+	if err != nil {
+		log.Println(err)
+		correction.NoCorrectionReason = bolus.BloodGlucoseUnavailable
+	} else {
+		correction.CurrentBloodGlucoseLevelInMgDl = reading.ValueInMgDl
+		bloodGlucose.Value = bloodGlucose.Unit.FromMgDl(reading.ValueInMgDl)
+		bloodGlucose.ReadingTime = &reading.Time
 
-		currentBloodGlucoseReading, err := s.dexcomClient.GetCurrentBloodGlucoseReading()
-		if err != nil {
-			log.Println(err)
-			http.Error(response, err.Error(), http.StatusInternalServerError)
-			return
+		if now.Sub(reading.Time) > me.maxReadingAge() {
+			correction.NoCorrectionReason = bolus.StaleBloodGlucose
+		} else if reading.TrendMethod == cgm.NoTrend {
+			correction.NoCorrectionReason = bolus.TrendNotComputable
+		} else {
+			correction.BloodGlucoseTrendInMgDlIn15Mins = reading.TrendInMgDlIn15Mins
+			correction.BloodGlucoseTrendMethod = string(reading.TrendMethod)
+			bloodGlucose.TrendIn15Mins = bloodGlucose.Unit.FromMgDl(reading.TrendInMgDlIn15Mins)
 		}
+	}
 
-		dose = bolus.GetDose(bolus.DoseInput{
-			FoodInput: bolus.FoodInput{
-				TotalGramsOfCarbs:                input.TotalGramsOfCarbs,
-				GramsOfFiber:                     input.GramsOfFiber,
-				FiberMultiplier:                  data.FiberMultiplier,
-				GramsOfSugarAlcohol:              input.GramsOfSugarAlcohol,
-				SugarAlcoholMultiplier:           data.SugarAlcoholMultiplier,
-				GramsOfProtein:                   input.GramsOfProtein,
-				ProteinMultiplier:                data.ProteinMultiplier,
-				CarbThresholdToCountProteinUnder: data.CarbThresholdToCountProteinUnder,
-				InsulinToCarbRatio:               data.InsulinToCarbRatio,
-			},
-			CorrectionInput: bolus.CorrectionInput{
-				CurrentBloodGlucoseLevelInMgDl:  float32(currentBloodGlucoseReading.Value),
-				BloodGlucoseTrendInMgDlIn15Mins: float32(currentBloodGlucoseReading.Get15MinDeltaFromTrend()),
-				TargetBloodGlucoseLevelInMgDl:   data.TargetBloodGlucoseLevelInMgDl,
-				InsulinSensitivityFactor:        data.InsulinSensitivityFactor,
-			},
-			InsulinOnBoardInput: bolus.InsulinOnBoardInput{
-				LastBolusTime:           data.LastBolusTime,
-				LastBolusUnitsOfInsulin: data.LastBolusUnitsOfInsulin,
-			},
-			ExerciseInput: bolus.ExerciseInput{
-				MinutesOfExercise: input.MinutesOfExercise,
-				ExerciseIntensity: input.ExerciseIntensity,
-			},
-		})
+	var boluses []bolus.Bolus
+	var meals []bolus.Meal
+	u.logbook.Read(func(logbook *Logbook) {
+		boluses = logbook.BolusesSince(now.Add(-insulinModel.Duration()))
+		meals = logbook.MealsSince(now.Add(-bolus.MaxCarbAbsorptionTime))
 	})
 
-	response.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(response).Encode(dose)
+	return bolus.DoseInput{
+		Time:     now,
+		Location: location,
+		FoodInput: bolus.FoodInput{
+			TotalGramsOfCarbs:                input.TotalGramsOfCarbs,
+			GramsOfFiber:                     input.GramsOfFiber,
+			FiberMultiplier:                  me.FiberMultiplier,
+			GramsOfSugarAlcohol:              input.GramsOfSugarAlcohol,
+			SugarAlcoholMultiplier:           me.SugarAlcoholMultiplier,
+			GramsOfProtein:                   input.GramsOfProtein,
+			ProteinMultiplier:                me.ProteinMultiplier,
+			CarbThresholdToCountProteinUnder: me.CarbThresholdToCountProteinUnder,
+			GramsOfFat:                       input.GramsOfFat,
+			FatProteinUnitMultiplier:         me.FatProteinUnitMultiplier,
+			InsulinToCarbRatio:               me.InsulinToCarbRatio,
+		},
+		CorrectionInput: correction,
+		InsulinOnBoardInput: bolus.InsulinOnBoardInput{
+			Boluses:      boluses,
+			InsulinModel: insulinModel,
+		},
+		CarbsOnBoardInput: bolus.CarbsOnBoardInput{
+			Meals:               meals,
+			CarbAbsorptionModel: me.CarbAbsorptionModel,
+		},
+		ExerciseInput: bolus.ExerciseInput{
+			MinutesOfExercise: input.MinutesOfExercise,
+			ExerciseIntensity: input.ExerciseIntensity,
+		},
+		SafetyInput: bolus.SafetyInput{
+			MaxBolusUnitsOfInsulin:           me.MaxBolusUnits,
+			MaxInsulinOnBoard:                me.MaxInsulinOnBoard,
+			LowGlucoseSuspendThresholdInMgDl: me.LowGlucoseSuspendThresholdInMgDl,
+		},
+		DeliveryInput: bolus.DeliveryInput{
+			DeliveryDevice: me.DeliveryDevice,
+			RoundingPolicy: me.RoundingPolicy,
+		},
+	}, bloodGlucose, nil
 }
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+func TestDoseHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// Changes the reading of the fake Glucose Source
+		source                 func(source *fakeSource, now time.Time)
+		body                   string
+		expectedUnitsOfInsulin float32
+		expectedWarnings       []bolus.WarningCode
+		expectedSource         string
+	}{
+		{
+			name:                   "cgm",
+			body:                   `{"total_grams_of_carbs": 20}`,
+			expectedUnitsOfInsulin: 3,
+			expectedSource:         "fake",
+		},
+		{
+			name:                   "stale",
+			source:                 func(source *fakeSource, now time.Time) { source.reading.Time = now.Add(-15 * time.Minute) },
+			body:                   `{"total_grams_of_carbs": 20}`,
+			expectedUnitsOfInsulin: 2,
+			expectedWarnings:       []bolus.WarningCode{bolus.StaleBloodGlucose},
+			expectedSource:         "fake",
+		},
+		{
+			name:                   "unavailable",
+			source:                 func(source *fakeSource, now time.Time) { source.err = errors.New("no connection") },
+			body:                   `{"total_grams_of_carbs": 20}`,
+			expectedUnitsOfInsulin: 2,
+			expectedWarnings:       []bolus.WarningCode{bolus.BloodGlucoseUnavailable},
+			expectedSource:         "fake",
+		},
+		{
+			name:                   "trendless",
+			source:                 func(source *fakeSource, now time.Time) { source.reading.TrendMethod = cgm.NoTrend },
+			body:                   `{"total_grams_of_carbs": 20}`,
+			expectedUnitsOfInsulin: 2,
+			expectedWarnings:       []bolus.WarningCode{bolus.TrendNotComputable},
+			expectedSource:         "fake",
+		},
+		{
+			name:                   "manual",
+			source:                 func(source *fakeSource, now time.Time) { source.err = errors.New("no connection") },
+			body:                   `{"total_grams_of_carbs": 20, "current_blood_glucose": 200, "trend": "flat"}`,
+			expectedUnitsOfInsulin: 4,
+			expectedSource:         "manual",
+		},
+		{
+			name:                   "manual falling",
+			body:                   `{"total_grams_of_carbs": 20, "current_blood_glucose": 200, "trend": "falling"}`,
+			expectedUnitsOfInsulin: 3.4,
+			expectedSource:         "manual",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, "alex")
+			ts.onboard(t, "alex")
+			if tc.source != nil {
+				tc.source(ts.users["alex"].glucoseSource.(*fakeSource), ts.now)
+			}
+
+			var dose DoseOutput
+			response := ts.do(t, "alex", http.MethodPost, "/dose", tc.body, &dose)
+			if response.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+			}
+			if dose.UnitsOfInsulin != tc.expectedUnitsOfInsulin {
+				t.Errorf("expected %f, got %f", tc.expectedUnitsOfInsulin, dose.UnitsOfInsulin)
+			}
+			var warnings []bolus.WarningCode
+			for _, warning := range dose.Warnings {
+				warnings = append(warnings, warning.Code)
+			}
+			if !slices.Equal(warnings, tc.expectedWarnings) {
+				t.Errorf("expected %v warnings, got %v", tc.expectedWarnings, warnings)
+			}
+			if dose.BloodGlucose.Source != tc.expectedSource {
+				t.Errorf("expected %s, got %s", tc.expectedSource, dose.BloodGlucose.Source)
+			}
+		})
+	}
+}
+
+func TestDoseHandlerValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		settings       string
+		body           string
+		expectedErrors []*bolus.ValidationError
+	}{
+		{
+			name: "manual glucose and trend",
+			body: `{"total_grams_of_carbs": 20, "current_blood_glucose": -5, "trend": "sideways"}`,
+			expectedErrors: []*bolus.ValidationError{
+				{Field: "current_blood_glucose", Message: "must be positive"},
+				{Field: "trend", Message: "must be one of rising_quickly, rising, rising_slowly, flat, falling_slowly, falling, or falling_quickly"},
+			},
+		},
+		{
+			name:     "target in mmol/L",
+			settings: `{"glucose_unit": "mmol/L", "target_blood_glucose": 3}`,
+			body:     `{"total_grams_of_carbs": 20}`,
+			expectedErrors: []*bolus.ValidationError{
+				{Field: "target_blood_glucose", Message: "must be set above 3.1 mmol/L"},
+			},
+		},
+		{
+			name: "negative carbs",
+			body: `{"total_grams_of_carbs": -20}`,
+			expectedErrors: []*bolus.ValidationError{
+				{Field: "total_grams_of_carbs", Message: "must not be negative"},
+			},
+		},
+		{
+			name: "carb absorption time",
+			body: `{"total_grams_of_carbs": 20, "carb_absorption_time_in_minutes": 600}`,
+			expectedErrors: []*bolus.ValidationError{
+				{Field: "carb_absorption_time_in_minutes", Message: "must be between 0 and 480"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, "alex")
+			ts.onboard(t, "alex")
+			if tc.settings != "" {
+				ts.do(t, "alex", http.MethodPatch, "/me", tc.settings, nil)
+			}
+
+			response := ts.do(t, "alex", http.MethodPost, "/dose", tc.body, nil)
+			if response.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", response.Code, response.Body)
+			}
+			var output ErrorResponse
+			if err := json.NewDecoder(response.Body).Decode(&output); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(output.Errors, tc.expectedErrors, func(a, b *bolus.ValidationError) bool { return *a == *b }) {
+				t.Errorf("expected %+v, got %+v", tc.expectedErrors, output.Errors)
+			}
+
+			var doses DosesOutput
+			ts.do(t, "alex", http.MethodGet, "/doses", "", &doses)
+			if doses.Total != 0 {
+				t.Errorf("expected no dose to be recorded, got %d", doses.Total)
+			}
+		})
+	}
+}
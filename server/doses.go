@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+const (
+	DefaultDosesLimit = 20
+	MaxDosesLimit     = 100
+)
+
+type DosesOutput struct {
+	// Most recent first
+	Doses []DoseRecord
+	// Number of Doses in the time range
+	Total int
+	// Offset of the next page, unset on the last page
+	NextOffset *int
+}
+
+// DosesHandlerGet returns the recorded dose calculations in a time range, most recent first. Query
+// parameters are from and to (RFC 3339, or "now"), and limit and offset to page through them.
+func (s *Server) DosesHandlerGet(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	query := request.URL.Query()
+	now := s.now()
+	var errs bolus.ValidationErrors
+	from := time.Time{}
+	if value := query.Get("from"); value != "" {
+		var err error
+		from, err = parseTime(value, now)
+		if err != nil {
+			errs = append(errs, &bolus.ValidationError{Field: "from", Message: err.Error()})
+		}
+	}
+	// Doses are never in the future, so this is after all of them
+	to := now.Add(time.Nanosecond)
+	if value := query.Get("to"); value != "" {
+		var err error
+		to, err = parseTime(value, now)
+		if err != nil {
+			errs = append(errs, &bolus.ValidationError{Field: "to", Message: err.Error()})
+		}
+	}
+	limit, err := queryInt(query.Get("limit"), DefaultDosesLimit)
+	if err != nil || limit < 1 || limit > MaxDosesLimit {
+		errs = append(errs, &bolus.ValidationError{Field: "limit", Message: "must be a whole number between 1 and 100"})
+	}
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		errs = append(errs, &bolus.ValidationError{Field: "offset", Message: "must be a whole number, 0 or more"})
+	}
+	if len(errs) > 0 {
+		writeDoseError(response, errs)
+		return
+	}
+
+	var doses []DoseRecord
+	u.logbook.Read(func(logbook *Logbook) {
+		doses = logbook.DosesBetween(from, to)
+		if query.Get("unconfirmed") == "true" {
+			doses = logbook.UnconfirmedDosesSince(from)
+			doses = slices.DeleteFunc(doses, func(dose DoseRecord) bool {
+				return !dose.Time.Before(to)
+			})
+		}
+	})
+
+	output := DosesOutput{Doses: []DoseRecord{}, Total: len(doses)}
+	// Doses are oldest first, so page backwards from the end
+	for i := len(doses) - 1 - offset; i >= 0 && len(output.Doses) < limit; i-- {
+		output.Doses = append(output.Doses, doses[i])
+	}
+	output.Doses = u.withSettings(output.Doses)
+	if next := offset + len(output.Doses); next < len(doses) {
+		output.NextOffset = &next
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(output)
+}
+
+var (
+	errDoseNotFound         = errors.New("dose not found")
+	errDoseAlreadyConfirmed = errors.New("dose already confirmed")
+)
+
+// DoseConfirmHandlerPost logs the Bolus taken for a Dose, which defaults to the Dose recommended now
+func (s *Server) DoseConfirmHandlerPost(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	decoder := json.NewDecoder(request.Body)
+	input := BolusInput{}
+	err := decoder.Decode(&input)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b := bolus.Bolus{Time: s.now()}
+	if input.Time != nil {
+		b.Time, err = parseTime(*input.Time, s.now())
+		if err != nil {
+			http.Error(response, "could not parse time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var record DoseRecord
+	err = u.logbook.Write(func(logbook *Logbook) error {
+		i := logbook.FindDose(request.PathValue("id"))
+		if i < 0 {
+			return errDoseNotFound
+		}
+		if logbook.Doses[i].ConfirmedBolus != nil {
+			return errDoseAlreadyConfirmed
+		}
+
+		b.UnitsOfInsulin = logbook.Doses[i].Output.RoundedUnitsOfInsulin + logbook.Doses[i].Output.RoundedExtendedUnitsOfInsulin
+		if input.UnitsOfInsulin != nil {
+			b.UnitsOfInsulin = *input.UnitsOfInsulin
+		}
+		if b.UnitsOfInsulin <= 0 {
+			return errBolusUnitsRequired
+		}
+
+		logbook.Doses[i].ConfirmedBolus = &b
+		logbook.RecordBolus(b)
+		record = logbook.Doses[i]
+		return nil
+	})
+	switch {
+	case errors.Is(err, errDoseNotFound):
+		http.Error(response, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errDoseAlreadyConfirmed):
+		http.Error(response, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errBolusUnitsRequired):
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(u.withSettings([]DoseRecord{record})[0])
+}
+
+// queryInt parses a whole number query parameter, or returns the default if it is not set
+func queryInt(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+func TestDosesHandlerGet(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+
+	start := ts.now
+	var times []time.Time
+	for range 5 {
+		ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, nil)
+		times = append(times, ts.now)
+		ts.now = ts.now.Add(10 * time.Minute)
+	}
+
+	var doses DosesOutput
+	ts.do(t, "alex", http.MethodGet, "/doses?limit=2", "", &doses)
+	if doses.Total != 5 || len(doses.Doses) != 2 || !doses.Doses[0].Time.Equal(times[4]) || !doses.Doses[1].Time.Equal(times[3]) {
+		t.Errorf("expected the 2 most recent of 5 doses, got %+v", doses)
+	}
+	if doses.NextOffset == nil || *doses.NextOffset != 2 {
+		t.Errorf("expected a next offset of 2, got %v", doses.NextOffset)
+	}
+	if doses.Doses[0].Settings == nil || doses.Doses[0].Settings.InsulinToCarbRatio.GetAtTime(start) != 10 {
+		t.Errorf("expected the settings of the dose, got %+v", doses.Doses[0].Settings)
+	}
+
+	ts.do(t, "alex", http.MethodGet, "/doses?limit=2&offset=4", "", &doses)
+	if len(doses.Doses) != 1 || !doses.Doses[0].Time.Equal(times[0]) || doses.NextOffset != nil {
+		t.Errorf("expected the oldest dose on the last page, got %+v", doses)
+	}
+
+	from := start.Add(10 * time.Minute).Format(time.RFC3339)
+	to := start.Add(30 * time.Minute).Format(time.RFC3339)
+	ts.do(t, "alex", http.MethodGet, "/doses?from="+from+"&to="+to, "", &doses)
+	if doses.Total != 2 || !doses.Doses[0].Time.Equal(times[2]) || !doses.Doses[1].Time.Equal(times[1]) {
+		t.Errorf("expected the doses from 10 to 30 minutes, got %+v", doses)
+	}
+
+	for _, query := range []string{"limit=0", "limit=101", "offset=-1", "from=yesterday"} {
+		if response := ts.do(t, "alex", http.MethodGet, "/doses?"+query, "", nil); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, response.Code)
+		}
+	}
+}
+
+func TestDoseConfirmHandlerPost(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+
+	var first, second DoseOutput
+	ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, &first)
+	ts.now = ts.now.Add(30 * time.Minute)
+	ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, &second)
+	if !slices.ContainsFunc(second.Warnings, func(w bolus.Warning) bool { return w.Code == bolus.UnconfirmedDose }) {
+		t.Errorf("expected an unconfirmed dose warning, got %+v", second.Warnings)
+	}
+
+	var doses DosesOutput
+	ts.do(t, "alex", http.MethodGet, "/doses?unconfirmed=true", "", &doses)
+	if doses.Total != 2 {
+		t.Errorf("expected 2 unconfirmed doses, got %d", doses.Total)
+	}
+
+	var record DoseRecord
+	response := ts.do(t, "alex", http.MethodPost, "/doses/"+first.Id+"/confirm", `{"units_of_insulin": 2.5}`, &record)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if record.ConfirmedBolus == nil || record.ConfirmedBolus.UnitsOfInsulin != 2.5 {
+		t.Errorf("expected a confirmed bolus of 2.5, got %+v", record.ConfirmedBolus)
+	}
+	// The Bolus logged after the first Dose also counts for the second
+	ts.do(t, "alex", http.MethodGet, "/doses?unconfirmed=true", "", &doses)
+	if doses.Total != 0 {
+		t.Errorf("expected no unconfirmed doses, got %+v", doses.Doses)
+	}
+
+	if response := ts.do(t, "alex", http.MethodPost, "/doses/"+first.Id+"/confirm", "", nil); response.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", response.Code)
+	}
+	if response := ts.do(t, "alex", http.MethodPost, "/doses/unknown/confirm", "", nil); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", response.Code)
+	}
+	if response := ts.do(t, "alex", http.MethodPost, "/doses/"+second.Id+"/confirm", `{"units_of_insulin": 0}`, nil); response.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", response.Code)
+	}
+}
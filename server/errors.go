@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+type ErrorResponse struct {
+	Errors []*bolus.ValidationError `json:"errors"`
+}
+
+// validationErrorsInGlucoseUnit returns err with the Blood Glucose limits of its bolus.ValidationErrors
+// (which bolus gives in mg/dL) in the user's Glucose Unit
+func validationErrorsInGlucoseUnit(err error, unit bolus.GlucoseUnit) error {
+	var validationErrors bolus.ValidationErrors
+	if unit != bolus.MmolL || !errors.As(err, &validationErrors) {
+		return err
+	}
+	converted := make(bolus.ValidationErrors, len(validationErrors))
+	for i, validationError := range validationErrors {
+		converted[i] = validationError
+		if validationError.Field == "target_blood_glucose" {
+			converted[i] = &bolus.ValidationError{Field: validationError.Field, Message: bolus.MinTargetBloodGlucoseLevelMessage(unit)}
+		}
+	}
+	return converted
+}
+
+// writeDoseError responds with 400 and the invalid fields for bolus.ValidationErrors, and 500
+// otherwise
+func writeDoseError(response http.ResponseWriter, err error) {
+	var validationErrors bolus.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(response).Encode(ErrorResponse{Errors: validationErrors})
+}
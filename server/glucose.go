@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+type GlucoseOutput struct {
+	// Name of the Glucose Source, for example "dexcom"
+	Source string
+	// Oldest first
+	Readings []GlucoseReadingOutput
+	// Unit of Value and TrendIn15Mins
+	Unit bolus.GlucoseUnit
+}
+
+type GlucoseReadingOutput struct {
+	Time          time.Time
+	Value         float32
+	TrendIn15Mins float32
+	// How TrendIn15Mins was determined, see cgm.TrendMethod
+	TrendMethod cgm.TrendMethod
+}
+
+// Hours of readings returned by default, also the most kept
+const MaxGlucoseHistoryHours = 24
+
+func (s *Server) GlucoseHandler(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+
+	hours := MaxGlucoseHistoryHours
+	if value := request.URL.Query().Get("hours"); value != "" {
+		var err error
+		hours, err = strconv.Atoi(value)
+		if err != nil || hours <= 0 || hours > MaxGlucoseHistoryHours {
+			writeDoseError(response, bolus.ValidationErrors{{Field: "hours", Message: "must be a whole number between 1 and 24"}})
+			return
+		}
+	}
+
+	var me Me
+	u.db.Read(func(data *Me) {
+		me = *data
+	})
+	unit := me.glucoseUnit()
+
+	output := GlucoseOutput{
+		Source:   u.glucoseSource.Name(),
+		Readings: []GlucoseReadingOutput{},
+		Unit:     unit,
+	}
+	for _, reading := range u.glucosePoller.Readings(s.now().Add(-time.Duration(hours) * time.Hour)) {
+		output.Readings = append(output.Readings, GlucoseReadingOutput{
+			Time:          reading.Time,
+			Value:         unit.FromMgDl(reading.ValueInMgDl),
+			TrendIn15Mins: unit.FromMgDl(reading.TrendInMgDlIn15Mins),
+			TrendMethod:   reading.TrendMethod,
+		})
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(output)
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGlucoseHandler(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+	ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, nil)
+
+	var glucose GlucoseOutput
+	response := ts.do(t, "alex", http.MethodGet, "/glucose?hours=1", "", &glucose)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if glucose.Source != "fake" || len(glucose.Readings) != 1 || glucose.Readings[0].Value != 150 {
+		t.Errorf("expected the reading polled for the dose, got %+v", glucose)
+	}
+
+	for _, hours := range []string{"0", "25", "one"} {
+		if response := ts.do(t, "alex", http.MethodGet, "/glucose?hours="+hours, "", nil); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", hours, response.Code)
+		}
+	}
+}
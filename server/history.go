@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History records every version of the user's settings in Me, so changes can be reviewed and undone
+type History struct {
+	Versions []SettingsVersion `json:"versions"`
+}
+
+type SettingsVersion struct {
+	// Starts at 0, for the settings before the first recorded change
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// Who made the change (the client's User-Agent)
+	Author string `json:"author"`
+	// Set when the change rolled the settings back to this earlier version
+	RollbackOf *int `json:"rollback_of,omitempty"`
+	// The settings that changed, with Blood Glucose values in the Glucose Unit after the change
+	Changes []SettingChange `json:"changes"`
+	// The settings after the change (with Blood Glucose values in mg/dL)
+	Settings Me `json:"settings"`
+}
+
+type SettingChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+// Names the settings stored in mg/dL are given under in Changes, as their values there are in the Glucose
+// Unit
+var settingChangeFieldMap = map[string]string{
+	"target_blood_glucose_level_in_mg_dl":    "target_blood_glucose",
+	"low_glucose_suspend_threshold_in_mg_dl": "low_glucose_suspend_threshold",
+}
+
+// settingChanges returns the settings that differ between previous and updated. Whether a setting changed
+// is decided on the stored values (in mg/dL), so a change too small to show in mmol/L is still recorded.
+// Blood Glucose values are given in the updated Glucose Unit.
+func settingChanges(previous Me, updated Me) ([]SettingChange, error) {
+	previousFields, err := settingFields(previous)
+	if err != nil {
+		return nil, err
+	}
+	updatedFields, err := settingFields(updated)
+	if err != nil {
+		return nil, err
+	}
+	previousOutput, err := settingFields(previous.inGlucoseUnit(updated.GlucoseUnit))
+	if err != nil {
+		return nil, err
+	}
+	updatedOutput, err := settingFields(updated.InGlucoseUnit())
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []SettingChange
+	for _, field := range slices.Sorted(maps.Keys(updatedFields)) {
+		if bytes.Equal(previousFields[field], updatedFields[field]) {
+			continue
+		}
+		change := SettingChange{Field: field, Old: previousOutput[field], New: updatedOutput[field]}
+		if name, ok := settingChangeFieldMap[field]; ok {
+			change.Field = name
+		}
+		changes = append(changes, change)
+	}
+	slices.SortFunc(changes, func(a, b SettingChange) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return changes, nil
+}
+
+// settingFields returns the JSON value of each setting in Me
+func settingFields(me Me) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(me)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+// recordSettingsVersion adds a version to the History when the settings changed. The settings before
+// the first recorded change are recorded as version 0.
+func (u *user) recordSettingsVersion(now time.Time, previous Me, updated Me, author string, rollbackOf *int) error {
+	changes, err := settingChanges(previous, updated)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	return u.history.Write(func(history *History) error {
+		if len(history.Versions) == 0 {
+			history.Versions = append(history.Versions, SettingsVersion{Version: 0, Time: now, Settings: previous})
+		}
+		history.Versions = append(history.Versions, SettingsVersion{
+			Version:    len(history.Versions),
+			Time:       now,
+			Author:     author,
+			RollbackOf: rollbackOf,
+			Changes:    changes,
+			Settings:   updated,
+		})
+		return nil
+	})
+}
+
+// currentSettingsVersion returns the version of the current settings in the History, recording them as
+// version 0 if nothing is recorded yet
+func (u *user) currentSettingsVersion(now time.Time, current Me) (int, error) {
+	var version int
+	err := u.history.Write(func(history *History) error {
+		if len(history.Versions) == 0 {
+			history.Versions = append(history.Versions, SettingsVersion{Version: 0, Time: now, Settings: current})
+		}
+		version = len(history.Versions) - 1
+		return nil
+	})
+	return version, err
+}
+
+// withSettings returns the Doses with the settings of their version filled in from the History
+func (u *user) withSettings(doses []DoseRecord) []DoseRecord {
+	doses = slices.Clone(doses)
+	u.history.Read(func(history *History) {
+		for i, dose := range doses {
+			if dose.SettingsVersion != nil && *dose.SettingsVersion < len(history.Versions) {
+				settings := history.Versions[*dose.SettingsVersion].Settings
+				doses[i].Settings = &settings
+			}
+		}
+	})
+	return doses
+}
+
+type HistoryOutput struct {
+	// Most recent first
+	Versions []SettingsVersionOutput
+}
+
+type SettingsVersionOutput struct {
+	Version    int
+	Time       time.Time
+	Author     string
+	RollbackOf *int
+	Changes    []SettingChange
+}
+
+func (s *Server) MeHistoryHandlerGet(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	output := HistoryOutput{Versions: []SettingsVersionOutput{}}
+	u.history.Read(func(history *History) {
+		for _, version := range slices.Backward(history.Versions) {
+			output.Versions = append(output.Versions, SettingsVersionOutput{
+				Version:    version.Version,
+				Time:       version.Time,
+				Author:     version.Author,
+				RollbackOf: version.RollbackOf,
+				Changes:    version.Changes,
+			})
+		}
+	})
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(output)
+}
+
+var errVersionNotFound = errors.New("settings version not found")
+
+// MeRollbackHandlerPost restores the settings of an earlier version, recording it as a new version
+func (s *Server) MeRollbackHandlerPost(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	version, err := strconv.Atoi(request.PathValue("version"))
+	if err != nil {
+		http.Error(response, "version must be a whole number", http.StatusBadRequest)
+		return
+	}
+
+	var settings *Me
+	u.history.Read(func(history *History) {
+		if version >= 0 && version < len(history.Versions) {
+			settings = &history.Versions[version].Settings
+		}
+	})
+	if settings == nil {
+		http.Error(response, errVersionNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	var previous Me
+	err = u.db.Write(func(me *Me) error {
+		previous = *me
+		*me = *settings
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = u.recordSettingsVersion(s.now(), previous, *settings, request.UserAgent(), &version)
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("ETag", u.db.ETag())
+	json.NewEncoder(response).Encode(settings.output())
+}
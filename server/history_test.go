@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMeHistoryAndRollback(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+	ts.do(t, "alex", http.MethodPatch, "/me", `{"insulin_to_carb_ratio": 8}`, nil, "User-Agent", "test")
+
+	var history HistoryOutput
+	ts.do(t, "alex", http.MethodGet, "/me/history", "", &history)
+	// The empty settings, onboarding, and the change to the ratio
+	if len(history.Versions) != 3 {
+		t.Fatalf("expected 3 versions, got %+v", history.Versions)
+	}
+	latest := history.Versions[0]
+	if latest.Version != 2 || latest.Author != "test" || len(latest.Changes) != 1 || latest.Changes[0].Field != "insulin_to_carb_ratio" {
+		t.Errorf("unexpected latest version %+v", latest)
+	}
+
+	var me Me
+	response := ts.do(t, "alex", http.MethodPost, "/me/rollback/1", "", &me)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if ratio := me.InsulinToCarbRatio.GetAtTime(ts.now); ratio != 10 {
+		t.Errorf("expected the ratio to be rolled back to 10, got %f", ratio)
+	}
+	ts.do(t, "alex", http.MethodGet, "/me/history", "", &history)
+	if rollbackOf := history.Versions[0].RollbackOf; rollbackOf == nil || *rollbackOf != 1 {
+		t.Errorf("expected a rollback of version 1, got %v", rollbackOf)
+	}
+
+	for path, expected := range map[string]int{
+		"/me/rollback/9":   http.StatusNotFound,
+		"/me/rollback/-1":  http.StatusNotFound,
+		"/me/rollback/one": http.StatusBadRequest,
+	} {
+		if response := ts.do(t, "alex", http.MethodPost, path, "", nil); response.Code != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, response.Code)
+		}
+	}
+}
+
+func TestMeHistoryInMmolL(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+	ts.do(t, "alex", http.MethodPatch, "/me", `{"glucose_unit": "mmol/L", "insulin_sensitivity_factor": 2.2}`, nil)
+	// Changes that are small in mmol/L are still recorded
+	ts.do(t, "alex", http.MethodPatch, "/me", `{"insulin_sensitivity_factor": 2.24}`, nil)
+	ts.do(t, "alex", http.MethodPatch, "/me", `{"target_blood_glucose": 6}`, nil)
+
+	var history HistoryOutput
+	ts.do(t, "alex", http.MethodGet, "/me/history", "", &history)
+	if len(history.Versions) != 5 {
+		t.Fatalf("expected 5 versions, got %+v", history.Versions)
+	}
+	if changes := history.Versions[1].Changes; len(changes) != 1 || changes[0].Field != "insulin_sensitivity_factor" {
+		t.Errorf("expected a change to the factor, got %+v", changes)
+	}
+	// The target is in mmol/L, so it is not named in mg/dL
+	if changes := history.Versions[0].Changes; len(changes) != 1 || changes[0].Field != "target_blood_glucose" {
+		t.Errorf("expected a change to the target, got %+v", changes)
+	}
+
+	// Doses are recorded with the latest version
+	ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, nil)
+	var doses DosesOutput
+	ts.do(t, "alex", http.MethodGet, "/doses", "", &doses)
+	if version := doses.Doses[0].SettingsVersion; version == nil || *version != 4 {
+		t.Errorf("expected version 4, got %v", version)
+	}
+}
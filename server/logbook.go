@@ -0,0 +1,142 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+// Logbook records events (like Boluses, Meals, and Doses) separately from the user's settings in Me
+type Logbook struct {
+	Boluses []bolus.Bolus `json:"boluses"`
+	Meals   []bolus.Meal  `json:"meals"`
+	Doses   []DoseRecord  `json:"doses"`
+	// Boluses and Meals before this time were pruned
+	PrunedBefore time.Time `json:"pruned_before"`
+}
+
+// Boluses and Meals are kept for at least this long, even when they no longer affect a Dose
+const MinEventRetention = 24 * time.Hour
+
+// eventRetention returns how long Boluses and Meals are kept, which is longer than they affect a Dose for
+func eventRetention(insulinModel bolus.InsulinModel) time.Duration {
+	return max(MinEventRetention, insulinModel.Duration(), bolus.MaxCarbAbsorptionTime)
+}
+
+// Prune removes the Boluses and Meals before the given time. Doses are kept, as their settings are only
+// stored as a version of the History.
+func (l *Logbook) Prune(before time.Time) {
+	if !before.After(l.PrunedBefore) {
+		return
+	}
+	l.Boluses = slices.Clone(l.BolusesSince(before))
+	l.Meals = slices.Clone(l.MealsSince(before))
+	l.PrunedBefore = before
+}
+
+// A DoseRecord is a dose calculation, with everything it was calculated from
+type DoseRecord struct {
+	Id   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// The request
+	Input DoseInput `json:"input"`
+	// Version of the user's settings at the time in the History
+	SettingsVersion *int `json:"settings_version,omitempty"`
+	// The user's settings at the time (with Blood Glucose values in mg/dL). Only stored for Doses recorded
+	// before SettingsVersion, otherwise it is filled in from the History when responding.
+	Settings *Me `json:"settings,omitempty"`
+	// The response, including the Blood Glucose reading used
+	Output DoseOutput `json:"output"`
+	// The Bolus taken for the Dose, unset until it is confirmed
+	ConfirmedBolus *bolus.Bolus `json:"confirmed_bolus"`
+}
+
+// Unconfirmed reports whether insulin was recommended for the Dose, but it was not confirmed as taken
+func (d DoseRecord) Unconfirmed() bool {
+	return d.ConfirmedBolus == nil && d.Output.RoundedUnitsOfInsulin+d.Output.RoundedExtendedUnitsOfInsulin > 0
+}
+
+// FindDose returns the index of the Dose with the given ID, or -1 if there is none
+func (l *Logbook) FindDose(id string) int {
+	for i, dose := range l.Doses {
+		if dose.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// UnconfirmedDosesSince returns the Doses recommended at or after the given time that were not
+// confirmed, and that no Bolus was logged after. Doses from before the Logbook was pruned are left out, as
+// the Boluses logged after them may have been pruned.
+func (l *Logbook) UnconfirmedDosesSince(t time.Time) []DoseRecord {
+	if t.Before(l.PrunedBefore) {
+		t = l.PrunedBefore
+	}
+	var unconfirmed []DoseRecord
+	for _, dose := range l.DosesSince(t) {
+		if dose.Unconfirmed() && len(l.BolusesSince(dose.Time)) == 0 {
+			unconfirmed = append(unconfirmed, dose)
+		}
+	}
+	return unconfirmed
+}
+
+// RecordBolus adds a Bolus to the Logbook, keeping Boluses in time order
+func (l *Logbook) RecordBolus(b bolus.Bolus) {
+	l.Boluses = append(l.Boluses, b)
+	sort.SliceStable(l.Boluses, func(i, j int) bool {
+		return l.Boluses[i].Time.Before(l.Boluses[j].Time)
+	})
+}
+
+// BolusesSince returns the Boluses taken at or after the given time
+func (l *Logbook) BolusesSince(t time.Time) []bolus.Bolus {
+	i := sort.Search(len(l.Boluses), func(i int) bool {
+		return !l.Boluses[i].Time.Before(t)
+	})
+	return l.Boluses[i:]
+}
+
+// RecordMeal adds a Meal to the Logbook, keeping Meals in time order
+func (l *Logbook) RecordMeal(m bolus.Meal) {
+	l.Meals = append(l.Meals, m)
+	sort.SliceStable(l.Meals, func(i, j int) bool {
+		return l.Meals[i].Time.Before(l.Meals[j].Time)
+	})
+}
+
+// RecordDose adds a DoseRecord to the Logbook, keeping Doses in time order
+func (l *Logbook) RecordDose(d DoseRecord) {
+	l.Doses = append(l.Doses, d)
+	sort.SliceStable(l.Doses, func(i, j int) bool {
+		return l.Doses[i].Time.Before(l.Doses[j].Time)
+	})
+}
+
+// DosesSince returns the Doses calculated at or after the given time
+func (l *Logbook) DosesSince(t time.Time) []DoseRecord {
+	i := sort.Search(len(l.Doses), func(i int) bool {
+		return !l.Doses[i].Time.Before(t)
+	})
+	return l.Doses[i:]
+}
+
+// DosesBetween returns the Doses calculated at or after from, and before to
+func (l *Logbook) DosesBetween(from time.Time, to time.Time) []DoseRecord {
+	doses := l.DosesSince(from)
+	j := sort.Search(len(doses), func(j int) bool {
+		return !doses[j].Time.Before(to)
+	})
+	return doses[:j]
+}
+
+// MealsSince returns the Meals eaten at or after the given time
+func (l *Logbook) MealsSince(t time.Time) []bolus.Meal {
+	i := sort.Search(len(l.Meals), func(i int) bool {
+		return !l.Meals[i].Time.Before(t)
+	})
+	return l.Meals[i:]
+}
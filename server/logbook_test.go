@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+func TestLogbookPrune(t *testing.T) {
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	logbook := Logbook{}
+	logbook.RecordDose(DoseRecord{Id: "old", Time: now.Add(-30 * time.Hour), Output: DoseOutput{Dose: bolus.Dose{RoundedUnitsOfInsulin: 2}}})
+	logbook.RecordBolus(bolus.Bolus{Time: now.Add(-29 * time.Hour), UnitsOfInsulin: 2})
+	logbook.RecordMeal(bolus.Meal{Time: now.Add(-29 * time.Hour), GramsOfCarbs: 30})
+	logbook.RecordBolus(bolus.Bolus{Time: now.Add(-time.Hour), UnitsOfInsulin: 1})
+	logbook.RecordMeal(bolus.Meal{Time: now.Add(-time.Hour), GramsOfCarbs: 15})
+
+	logbook.Prune(now.Add(-eventRetention(bolus.LegacyInsulinModel)))
+
+	if len(logbook.Boluses) != 1 || logbook.Boluses[0].UnitsOfInsulin != 1 {
+		t.Errorf("expected only the recent bolus, got %+v", logbook.Boluses)
+	}
+	if len(logbook.Meals) != 1 || logbook.Meals[0].GramsOfCarbs != 15 {
+		t.Errorf("expected only the recent meal, got %+v", logbook.Meals)
+	}
+	if len(logbook.Doses) != 1 {
+		t.Errorf("expected doses to be kept, got %d", len(logbook.Doses))
+	}
+	// The Bolus that followed the old Dose was pruned, so it is not known to be unconfirmed
+	if unconfirmed := logbook.UnconfirmedDosesSince(time.Time{}); len(unconfirmed) != 0 {
+		t.Errorf("expected no unconfirmed doses, got %+v", unconfirmed)
+	}
+}
@@ -5,57 +5,206 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/kennedyjustin/BolusGPT/bolus"
 )
 
 type Me struct {
-	FiberMultiplier                  float32                         `json:"fiber_multiplier"`
-	SugarAlcoholMultiplier           float32                         `json:"sugar_alcohol_multiplier"`
-	ProteinMultiplier                float32                         `json:"protein_multiplier"`
-	CarbThresholdToCountProteinUnder float32                         `json:"carb_threshold_to_count_protein_under"`
-	InsulinToCarbRatio               bolus.SimpleTimeSensitiveFactor `json:"insulin_to_carb_ratio"`
+	FiberMultiplier                  float32                            `json:"fiber_multiplier"`
+	SugarAlcoholMultiplier           float32                            `json:"sugar_alcohol_multiplier"`
+	ProteinMultiplier                float32                            `json:"protein_multiplier"`
+	CarbThresholdToCountProteinUnder float32                            `json:"carb_threshold_to_count_protein_under"`
+	FatProteinUnitMultiplier         float32                            `json:"fat_protein_unit_multiplier"`
+	InsulinToCarbRatio               bolus.ScheduledTimeSensitiveFactor `json:"insulin_to_carb_ratio"`
 
-	TargetBloodGlucoseLevelInMgDl float32                         `json:"target_blood_glucose_level_in_mg_dl"`
-	InsulinSensitivityFactor      bolus.SimpleTimeSensitiveFactor `json:"insulin_sensitivity_factor"`
+	// Unit for Blood Glucose values (and the Insulin Sensitivity Factor) in the API, "mg/dL" (default) or "mmol/L".
+	// Values are always stored in mg/dL.
+	GlucoseUnit                   bolus.GlucoseUnit                  `json:"glucose_unit"`
+	TargetBloodGlucoseLevelInMgDl bolus.ScheduledTimeSensitiveTarget `json:"target_blood_glucose_level_in_mg_dl"`
+	InsulinSensitivityFactor      bolus.ScheduledTimeSensitiveFactor `json:"insulin_sensitivity_factor"`
 
-	LastBolusTime           time.Time `json:"last_bolus_time"`
-	LastBolusUnitsOfInsulin float32   `json:"last_bolus_units_of_insulin"`
+	// IANA Time Zone used to evaluate schedules (for example "America/New_York")
+	Timezone string `json:"timezone"`
+
+	CarbAbsorptionModel                bolus.CarbAbsorptionModel `json:"carb_absorption_model"`
+	DefaultCarbAbsorptionTimeInMinutes float32                   `json:"default_carb_absorption_time_in_minutes"`
+
+	InsulinType                      bolus.InsulinType `json:"insulin_type"`
+	DurationOfInsulinActionInMinutes float32           `json:"duration_of_insulin_action_in_minutes"`
+	InsulinPeakTimeInMinutes         float32           `json:"insulin_peak_time_in_minutes"`
+
+	MaxBolusUnits                    float32 `json:"max_bolus_units"`
+	MaxInsulinOnBoard                float32 `json:"max_insulin_on_board"`
+	LowGlucoseSuspendThresholdInMgDl float32 `json:"low_glucose_suspend_threshold_in_mg_dl"`
+	// Minutes after which a CGM reading is too old to correct with (0 for DefaultMaxReadingAgeInMinutes)
+	MaxReadingAgeInMinutes float32 `json:"max_reading_age_in_minutes"`
+
+	DeliveryDevice bolus.DeliveryDevice `json:"delivery_device"`
+	RoundingPolicy bolus.RoundingPolicy `json:"rounding_policy"`
+}
+
+// Location returns the user's configured Time Zone, or Local if it is not set
+func (me *Me) Location() (*time.Location, error) {
+	if me.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(me.Timezone)
+}
+
+// glucoseUnit returns the user's Glucose Unit, or mg/dL if it is not set
+func (me *Me) glucoseUnit() bolus.GlucoseUnit {
+	if me.GlucoseUnit == "" {
+		return bolus.MgDl
+	}
+	return me.GlucoseUnit
+}
+
+// Minutes after which a CGM reading is too old to correct with, when the user has not set it. CGMs read
+// every 5 minutes and upload a few minutes later, so the latest reading is often this old.
+const DefaultMaxReadingAgeInMinutes = 10
+
+// maxReadingAge returns the age after which a CGM reading is too old to correct with
+func (me *Me) maxReadingAge() time.Duration {
+	minutes := me.MaxReadingAgeInMinutes
+	if minutes == 0 {
+		minutes = DefaultMaxReadingAgeInMinutes
+	}
+	return time.Duration(minutes * float32(time.Minute))
+}
+
+// InsulinModel returns the model of Insulin On Board for the user's insulin type
+func (me *Me) InsulinModel() (bolus.InsulinModel, error) {
+	return bolus.NewInsulinModel(
+		me.InsulinType,
+		time.Duration(me.DurationOfInsulinActionInMinutes*float32(time.Minute)),
+		time.Duration(me.InsulinPeakTimeInMinutes*float32(time.Minute)),
+	)
+}
+
+// InGlucoseUnit returns a copy of Me with Blood Glucose values converted from mg/dL to the user's Glucose Unit
+func (me Me) InGlucoseUnit() Me {
+	return me.inGlucoseUnit(me.GlucoseUnit)
+}
+
+// inGlucoseUnit returns a copy of Me with Blood Glucose values converted from mg/dL to the given Glucose Unit
+func (me Me) inGlucoseUnit(unit bolus.GlucoseUnit) Me {
+	if unit != bolus.MmolL {
+		return me
+	}
+	me.TargetBloodGlucoseLevelInMgDl = me.TargetBloodGlucoseLevelInMgDl.Convert(unit.FromMgDl)
+	me.InsulinSensitivityFactor = me.InsulinSensitivityFactor.Convert(unit.FromMgDl)
+	me.LowGlucoseSuspendThresholdInMgDl = unit.FromMgDl(me.LowGlucoseSuspendThresholdInMgDl)
+	return me
+}
+
+// MeOutput is Me with Blood Glucose values in the user's Glucose Unit, under names without a unit. The
+// deprecated names with a unit are kept in mg/dL.
+type MeOutput struct {
+	Me
+	TargetBloodGlucose         bolus.ScheduledTimeSensitiveTarget `json:"target_blood_glucose"`
+	LowGlucoseSuspendThreshold float32                            `json:"low_glucose_suspend_threshold"`
+
+	TargetBloodGlucoseLevelInMgDl    bolus.ScheduledTimeSensitiveTarget `json:"target_blood_glucose_level_in_mg_dl"`
+	LowGlucoseSuspendThresholdInMgDl float32                            `json:"low_glucose_suspend_threshold_in_mg_dl"`
+}
+
+// output returns the settings as they are responded with
+func (me Me) output() MeOutput {
+	converted := me.InGlucoseUnit()
+	return MeOutput{
+		Me:                               converted,
+		TargetBloodGlucose:               converted.TargetBloodGlucoseLevelInMgDl,
+		LowGlucoseSuspendThreshold:       converted.LowGlucoseSuspendThresholdInMgDl,
+		TargetBloodGlucoseLevelInMgDl:    me.TargetBloodGlucoseLevelInMgDl,
+		LowGlucoseSuspendThresholdInMgDl: me.LowGlucoseSuspendThresholdInMgDl,
+	}
 }
 
 func (s *Server) MeHandlerGet(response http.ResponseWriter, request *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
 
-	s.db.Read(func(me *Me) {
+	u.db.Read(func(me *Me) {
 		if me == nil {
 			http.Error(response, "please onboard", http.StatusNotFound)
 			return
 		}
 
 		response.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(response).Encode(me)
+		response.Header().Set("ETag", u.db.ETag())
+		json.NewEncoder(response).Encode(me.output())
 	})
 }
 
 type MeInput struct {
-	FiberMultiplier                  *float32                         `json:"fiber_multiplier"`
-	SugarAlcoholMultiplier           *float32                         `json:"sugar_alcohol_multiplier"`
-	ProteinMultiplier                *float32                         `json:"protein_multiplier"`
-	CarbThresholdToCountProteinUnder *float32                         `json:"carb_threshold_to_count_protein_under"`
-	InsulinToCarbRatio               *bolus.SimpleTimeSensitiveFactor `json:"insulin_to_carb_ratio"`
+	FiberMultiplier                  *float32                            `json:"fiber_multiplier"`
+	SugarAlcoholMultiplier           *float32                            `json:"sugar_alcohol_multiplier"`
+	ProteinMultiplier                *float32                            `json:"protein_multiplier"`
+	CarbThresholdToCountProteinUnder *float32                            `json:"carb_threshold_to_count_protein_under"`
+	FatProteinUnitMultiplier         *float32                            `json:"fat_protein_unit_multiplier"`
+	InsulinToCarbRatio               *bolus.ScheduledTimeSensitiveFactor `json:"insulin_to_carb_ratio"`
+
+	GlucoseUnit              *bolus.GlucoseUnit                  `json:"glucose_unit"`
+	TargetBloodGlucose       *bolus.ScheduledTimeSensitiveTarget `json:"target_blood_glucose"`
+	InsulinSensitivityFactor *bolus.ScheduledTimeSensitiveFactor `json:"insulin_sensitivity_factor"`
+	// Deprecated: use TargetBloodGlucose. In mg/dL whatever the Glucose Unit, and ignored when
+	// TargetBloodGlucose is set.
+	TargetBloodGlucoseLevelInMgDl *bolus.ScheduledTimeSensitiveTarget `json:"target_blood_glucose_level_in_mg_dl"`
 
-	TargetBloodGlucoseLevelInMgDl *float32                         `json:"target_blood_glucose_level_in_mg_dl"`
-	InsulinSensitivityFactor      *bolus.SimpleTimeSensitiveFactor `json:"insulin_sensitivity_factor"`
+	Timezone *string `json:"timezone"`
 
+	CarbAbsorptionModel                *bolus.CarbAbsorptionModel `json:"carb_absorption_model"`
+	DefaultCarbAbsorptionTimeInMinutes *float32                   `json:"default_carb_absorption_time_in_minutes"`
+
+	InsulinType                      *bolus.InsulinType `json:"insulin_type"`
+	DurationOfInsulinActionInMinutes *float32           `json:"duration_of_insulin_action_in_minutes"`
+	InsulinPeakTimeInMinutes         *float32           `json:"insulin_peak_time_in_minutes"`
+
+	MaxBolusUnits              *float32 `json:"max_bolus_units"`
+	MaxInsulinOnBoard          *float32 `json:"max_insulin_on_board"`
+	LowGlucoseSuspendThreshold *float32 `json:"low_glucose_suspend_threshold"`
+	MaxReadingAgeInMinutes     *float32 `json:"max_reading_age_in_minutes"`
+	// Deprecated: use LowGlucoseSuspendThreshold. In mg/dL whatever the Glucose Unit, and ignored when
+	// LowGlucoseSuspendThreshold is set.
+	LowGlucoseSuspendThresholdInMgDl *float32 `json:"low_glucose_suspend_threshold_in_mg_dl"`
+
+	DeliveryDevice *bolus.DeliveryDevice `json:"delivery_device"`
+	RoundingPolicy *bolus.RoundingPolicy `json:"rounding_policy"`
+
+	// Deprecated: use POST /bolus. When set, a Bolus is recorded in the Logbook.
 	LastBolusTime           *string  `json:"last_bolus_time"`
 	LastBolusUnitsOfInsulin *float32 `json:"last_bolus_units_of_insulin"`
 }
 
+// ifMatch reports whether an If-Match header (a list of ETags, or "*") matches the ETag. It matches when
+// the header is not set.
+func ifMatch(header string, etag string) bool {
+	if header == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// MeHandlerPatch updates the settings. With an If-Match header, it only updates them if they have not
+// changed since they were read with that ETag.
 func (s *Server) MeHandlerPatch(response http.ResponseWriter, request *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	u := userFrom(request.Context())
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !ifMatch(request.Header.Get("If-Match"), u.db.ETag()) {
+		http.Error(response, "settings changed since they were read, get them again", http.StatusPreconditionFailed)
+		return
+	}
 
 	decoder := json.NewDecoder(request.Body)
 	input := MeInput{}
@@ -66,7 +215,28 @@ func (s *Server) MeHandlerPatch(response http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	err = s.db.Write(func(me *Me) error {
+	var lastBolus *bolus.Bolus
+	if input.LastBolusTime != nil || input.LastBolusUnitsOfInsulin != nil {
+		if input.LastBolusUnitsOfInsulin == nil {
+			http.Error(response, "'last_bolus_units_of_insulin' required with 'last_bolus_time'", http.StatusBadRequest)
+			return
+		}
+		lastBolus = &bolus.Bolus{
+			Time:           s.now(),
+			UnitsOfInsulin: *input.LastBolusUnitsOfInsulin,
+		}
+		if input.LastBolusTime != nil {
+			lastBolus.Time, err = parseTime(*input.LastBolusTime, s.now())
+			if err != nil {
+				http.Error(response, "could not parse last_bolus_time: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var previous, updated Me
+	err = u.db.Write(func(me *Me) error {
+		previous = *me
 		if input.FiberMultiplier != nil {
 			me.FiberMultiplier = *input.FiberMultiplier
 		}
@@ -79,35 +249,111 @@ func (s *Server) MeHandlerPatch(response http.ResponseWriter, request *http.Requ
 		if input.CarbThresholdToCountProteinUnder != nil {
 			me.CarbThresholdToCountProteinUnder = *input.CarbThresholdToCountProteinUnder
 		}
+		if input.FatProteinUnitMultiplier != nil {
+			me.FatProteinUnitMultiplier = *input.FatProteinUnitMultiplier
+		}
 		if input.InsulinToCarbRatio != nil {
 			me.InsulinToCarbRatio = *input.InsulinToCarbRatio
 		}
 
-		if input.TargetBloodGlucoseLevelInMgDl != nil {
+		// Blood Glucose values in the input are in the (possibly just updated) Glucose Unit
+		if input.GlucoseUnit != nil {
+			if !input.GlucoseUnit.Valid() {
+				return errors.New("unknown glucose_unit: " + string(*input.GlucoseUnit))
+			}
+			me.GlucoseUnit = *input.GlucoseUnit
+		}
+		if input.TargetBloodGlucose != nil {
+			me.TargetBloodGlucoseLevelInMgDl = input.TargetBloodGlucose.Convert(me.GlucoseUnit.ToMgDl)
+		} else if input.TargetBloodGlucoseLevelInMgDl != nil {
 			me.TargetBloodGlucoseLevelInMgDl = *input.TargetBloodGlucoseLevelInMgDl
 		}
 		if input.InsulinSensitivityFactor != nil {
-			me.InsulinSensitivityFactor = *input.InsulinSensitivityFactor
+			me.InsulinSensitivityFactor = input.InsulinSensitivityFactor.Convert(me.GlucoseUnit.ToMgDl)
 		}
 
-		if input.LastBolusTime != nil {
-			if *input.LastBolusTime == "now" {
-				me.LastBolusTime = time.Now()
-			} else {
-				t, err := time.Parse(time.RFC3339, *input.LastBolusTime)
-				if err != nil {
-					return errors.New("could not parse last_bolus_time: " + err.Error())
-				}
-				me.LastBolusTime = t
+		if input.Timezone != nil {
+			_, err := time.LoadLocation(*input.Timezone)
+			if err != nil {
+				return errors.New("could not load timezone: " + err.Error())
+			}
+			me.Timezone = *input.Timezone
+		}
+
+		if input.CarbAbsorptionModel != nil {
+			switch *input.CarbAbsorptionModel {
+			case "", bolus.LinearCarbAbsorption, bolus.PiecewiseCarbAbsorption:
+				me.CarbAbsorptionModel = *input.CarbAbsorptionModel
+			default:
+				return errors.New("unknown carb_absorption_model: " + string(*input.CarbAbsorptionModel))
 			}
 		}
-		if input.LastBolusUnitsOfInsulin != nil {
-			me.LastBolusUnitsOfInsulin = *input.LastBolusUnitsOfInsulin
+		if input.DefaultCarbAbsorptionTimeInMinutes != nil {
+			if *input.DefaultCarbAbsorptionTimeInMinutes < 0 || *input.DefaultCarbAbsorptionTimeInMinutes > float32(bolus.MaxCarbAbsorptionTime.Minutes()) {
+				return errors.New("default_carb_absorption_time_in_minutes must be between 0 and 480")
+			}
+			me.DefaultCarbAbsorptionTimeInMinutes = *input.DefaultCarbAbsorptionTimeInMinutes
 		}
 
-		response.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(response).Encode(me)
+		if input.InsulinType != nil {
+			me.InsulinType = *input.InsulinType
+		}
+		if input.DurationOfInsulinActionInMinutes != nil {
+			me.DurationOfInsulinActionInMinutes = *input.DurationOfInsulinActionInMinutes
+		}
+		if input.InsulinPeakTimeInMinutes != nil {
+			me.InsulinPeakTimeInMinutes = *input.InsulinPeakTimeInMinutes
+		}
+		if _, err := me.InsulinModel(); err != nil {
+			return err
+		}
 
+		if input.MaxBolusUnits != nil {
+			if *input.MaxBolusUnits < 0 {
+				return errors.New("max_bolus_units must not be negative")
+			}
+			me.MaxBolusUnits = *input.MaxBolusUnits
+		}
+		if input.MaxInsulinOnBoard != nil {
+			if *input.MaxInsulinOnBoard < 0 {
+				return errors.New("max_insulin_on_board must not be negative")
+			}
+			me.MaxInsulinOnBoard = *input.MaxInsulinOnBoard
+		}
+		if input.LowGlucoseSuspendThreshold != nil {
+			if *input.LowGlucoseSuspendThreshold < 0 {
+				return errors.New("low_glucose_suspend_threshold must not be negative")
+			}
+			me.LowGlucoseSuspendThresholdInMgDl = me.GlucoseUnit.ToMgDl(*input.LowGlucoseSuspendThreshold)
+		} else if input.LowGlucoseSuspendThresholdInMgDl != nil {
+			if *input.LowGlucoseSuspendThresholdInMgDl < 0 {
+				return errors.New("low_glucose_suspend_threshold_in_mg_dl must not be negative")
+			}
+			me.LowGlucoseSuspendThresholdInMgDl = *input.LowGlucoseSuspendThresholdInMgDl
+		}
+		if input.MaxReadingAgeInMinutes != nil {
+			if *input.MaxReadingAgeInMinutes < 0 {
+				return errors.New("max_reading_age_in_minutes must not be negative")
+			}
+			me.MaxReadingAgeInMinutes = *input.MaxReadingAgeInMinutes
+		}
+
+		if input.DeliveryDevice != nil {
+			if _, ok := bolus.DeliveryIncrementMap[*input.DeliveryDevice]; !ok && *input.DeliveryDevice != "" {
+				return errors.New("unknown delivery_device: " + string(*input.DeliveryDevice))
+			}
+			me.DeliveryDevice = *input.DeliveryDevice
+		}
+		if input.RoundingPolicy != nil {
+			switch *input.RoundingPolicy {
+			case "", bolus.RoundDown, bolus.RoundNearest, bolus.RoundDownWhenFalling:
+				me.RoundingPolicy = *input.RoundingPolicy
+			default:
+				return errors.New("unknown rounding_policy: " + string(*input.RoundingPolicy))
+			}
+		}
+
+		updated = *me
 		return nil
 	})
 	if err != nil {
@@ -115,4 +361,27 @@ func (s *Server) MeHandlerPatch(response http.ResponseWriter, request *http.Requ
 		http.Error(response, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	err = u.recordSettingsVersion(s.now(), previous, updated, request.UserAgent(), nil)
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if lastBolus != nil {
+		err = u.logbook.Write(func(logbook *Logbook) error {
+			logbook.RecordBolus(*lastBolus)
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			http.Error(response, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("ETag", u.db.ETag())
+	json.NewEncoder(response).Encode(updated.output())
 }
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestMeHandlerPatch(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+
+	var me MeOutput
+	response := ts.do(t, "alex", http.MethodPatch, "/me", `{"glucose_unit": "mmol/L", "low_glucose_suspend_threshold": 3.9}`, &me)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if me.LowGlucoseSuspendThreshold != 3.9 {
+		t.Errorf("expected a threshold of 3.9, got %f", me.LowGlucoseSuspendThreshold)
+	}
+	// The deprecated names stay in mg/dL
+	if threshold := me.LowGlucoseSuspendThresholdInMgDl; math.Abs(float64(threshold)-70.3) > 0.1 {
+		t.Errorf("expected a threshold of 70.3 mg/dL, got %f", threshold)
+	}
+	if target := me.TargetBloodGlucose.GetAtTime(ts.now).Low; target != 5.5 {
+		t.Errorf("expected a target of 5.5, got %f", target)
+	}
+	if target := me.TargetBloodGlucoseLevelInMgDl.GetAtTime(ts.now).Low; target != 100 {
+		t.Errorf("expected a target of 100 mg/dL, got %f", target)
+	}
+
+	// The deprecated name still sets the target, in mg/dL
+	ts.do(t, "alex", http.MethodPatch, "/me", `{"target_blood_glucose_level_in_mg_dl": 108}`, &me)
+	if target := me.TargetBloodGlucose.GetAtTime(ts.now).Low; math.Abs(float64(target)-6) > 0.05 {
+		t.Errorf("expected a target of 6, got %f", target)
+	}
+	// The new name wins when both are set
+	ts.do(t, "alex", http.MethodPatch, "/me", `{"target_blood_glucose": 7, "target_blood_glucose_level_in_mg_dl": 108}`, &me)
+	if target := me.TargetBloodGlucose.GetAtTime(ts.now).Low; target != 7 {
+		t.Errorf("expected a target of 7, got %f", target)
+	}
+
+	for _, body := range []string{`{"glucose_unit": "mg/mL"}`, `{"low_glucose_suspend_threshold": -1}`, `{"low_glucose_suspend_threshold_in_mg_dl": -1}`, `{"timezone": "Mars/Olympus_Mons"}`} {
+		if response := ts.do(t, "alex", http.MethodPatch, "/me", body, nil); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, response.Code)
+		}
+	}
+}
+
+func TestMeHandlerPatchIfMatch(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+
+	response := ts.do(t, "alex", http.MethodGet, "/me", "", nil)
+	etag := response.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+
+	response = ts.do(t, "alex", http.MethodPatch, "/me", `{"insulin_to_carb_ratio": 8}`, nil, "If-Match", etag)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if response.Header().Get("ETag") == etag {
+		t.Errorf("expected the ETag to change")
+	}
+
+	// The settings changed since etag was read
+	response = ts.do(t, "alex", http.MethodPatch, "/me", `{"insulin_to_carb_ratio": 12}`, nil, "If-Match", etag)
+	if response.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412, got %d", response.Code)
+	}
+	var me MeOutput
+	ts.do(t, "alex", http.MethodGet, "/me", "", &me)
+	if ratio := me.InsulinToCarbRatio.GetAtTime(ts.now); ratio != 8 {
+		t.Errorf("expected the ratio to stay 8, got %f", ratio)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+// legacyMe holds the fields older versions stored in Me, before they moved to the Logbook
+type legacyMe struct {
+	LastBolusTime           time.Time `json:"last_bolus_time"`
+	LastBolusUnitsOfInsulin float32   `json:"last_bolus_units_of_insulin"`
+}
+
+// migrateLastBolus moves the last Bolus that older versions stored in the settings file at path to the
+// Logbook, then rewrites the settings without it. It can be run again if it is interrupted.
+func (u *user) migrateLastBolus(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var legacy legacyMe
+	if err := json.Unmarshal(b, &legacy); err != nil {
+		return err
+	}
+	if legacy.LastBolusTime.IsZero() || legacy.LastBolusUnitsOfInsulin <= 0 {
+		return nil
+	}
+
+	lastBolus := bolus.Bolus{Time: legacy.LastBolusTime, UnitsOfInsulin: legacy.LastBolusUnitsOfInsulin}
+	err = u.logbook.Write(func(logbook *Logbook) error {
+		for _, b := range logbook.BolusesSince(lastBolus.Time) {
+			if b == lastBolus {
+				return nil
+			}
+		}
+		logbook.RecordBolus(lastBolus)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Writing the settings drops the fields Me no longer has
+	return u.db.Write(func(*Me) error { return nil })
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+func TestMigrateLastBolus(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "me.json")
+	err := os.WriteFile(filePath, []byte(`{"insulin_to_carb_ratio": 10, "last_bolus_time": "2025-01-01T12:00:00Z", "last_bolus_units_of_insulin": 3}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := ServerInput{
+		FilePath:        filePath,
+		LogbookFilePath: filepath.Join(dir, "logbook.json"),
+		HistoryFilePath: filepath.Join(dir, "history.json"),
+		BearerToken:     "token",
+	}
+
+	// Loading again must not record the Bolus twice
+	for range 2 {
+		s, err := NewServer(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var boluses []bolus.Bolus
+		s.users["token"].logbook.Read(func(logbook *Logbook) {
+			boluses = logbook.Boluses
+		})
+		expected := bolus.Bolus{Time: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), UnitsOfInsulin: 3}
+		if len(boluses) != 1 || !boluses[0].Time.Equal(expected.Time) || boluses[0].UnitsOfInsulin != expected.UnitsOfInsulin {
+			t.Errorf("expected %+v, got %+v", expected, boluses)
+		}
+	}
+
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "last_bolus") {
+		t.Errorf("expected the last bolus to be removed from the settings, got %s", b)
+	}
+	if !strings.Contains(string(b), `"insulin_to_carb_ratio": 10`) {
+		t.Errorf("expected the settings to be kept, got %s", b)
+	}
+}
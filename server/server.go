@@ -1,76 +1,126 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
-	"sync"
+	"time"
 
-	"github.com/kennedyjustin/BolusGPT/dexcom"
-	"github.com/kennedyjustin/BolusGPT/jsonfile"
+	"github.com/kennedyjustin/BolusGPT/cgm"
 )
 
 type Server struct {
-	mu           sync.Mutex
-	server       *http.Server
-	db           *jsonfile.JSONFile[Me]
-	dexcomClient *dexcom.Client
-	bearerToken  string
+	server *http.Server
+	// Users by Bearer Token
+	users        map[string]*user
+	now          func() time.Time
+	pollInterval time.Duration
 }
 
 type ServerInput struct {
-	FilePath       string
-	DexcomUsername string
-	DexcomPassword string
-	BearerToken    string
+	// Users of the server, each with their own Bearer Token. When there are none, the single user is
+	// configured with the fields below.
+	Users []UserInput
+
+	FilePath        string
+	LogbookFilePath string
+	HistoryFilePath string
+	// Source of the current Blood Glucose (for example *dexcom.Client)
+	GlucoseSource cgm.Source
+	BearerToken   string
+
+	// Clock used for dose calculations and logged times (defaults to time.Now)
+	Now func() time.Time
+	// How often to poll each Glucose Source (defaults to cgm.DefaultPollInterval)
+	PollInterval time.Duration
 }
 
 func NewServer(input ServerInput) (*Server, error) {
-	server := &Server{}
-
-	db, err := jsonfile.LoadOrNew[Me](input.FilePath)
-	if err != nil {
-		return nil, err
+	server := &Server{
+		users:        map[string]*user{},
+		now:          input.Now,
+		pollInterval: input.PollInterval,
+	}
+	if server.now == nil {
+		server.now = time.Now
 	}
-	server.db = db
 
-	dexcomClient, err := dexcom.NewClient(dexcom.ClientInput{
-		Username: input.DexcomUsername,
-		Password: input.DexcomPassword,
-	})
-	if err != nil {
-		return nil, err
+	users := input.Users
+	if len(users) == 0 {
+		users = []UserInput{{
+			BearerToken:     input.BearerToken,
+			FilePath:        input.FilePath,
+			LogbookFilePath: input.LogbookFilePath,
+			HistoryFilePath: input.HistoryFilePath,
+			GlucoseSource:   input.GlucoseSource,
+		}}
+	} else {
+		for _, u := range users {
+			if u.BearerToken == "" {
+				return nil, errors.New("bearer token required for user " + u.Name)
+			}
+		}
+	}
+	for _, userInput := range users {
+		if _, ok := server.users[userInput.BearerToken]; ok {
+			return nil, errors.New("bearer token of user " + userInput.Name + " is not unique")
+		}
+		u, err := server.newUser(userInput)
+		if err != nil {
+			return nil, err
+		}
+		server.users[userInput.BearerToken] = u
 	}
-	server.dexcomClient = dexcomClient
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /me", server.Auth(server.MeHandlerGet))
 	mux.HandleFunc("PATCH /me", server.Auth(server.MeHandlerPatch))
+	mux.HandleFunc("GET /me/history", server.Auth(server.MeHistoryHandlerGet))
+	mux.HandleFunc("POST /me/rollback/{version}", server.Auth(server.MeRollbackHandlerPost))
 	mux.HandleFunc("POST /dose", server.Auth(server.DoseHandler))
+	mux.HandleFunc("POST /bolus", server.Auth(server.BolusHandlerPost))
+	mux.HandleFunc("GET /doses", server.Auth(server.DosesHandlerGet))
+	mux.HandleFunc("POST /doses/{id}/confirm", server.Auth(server.DoseConfirmHandlerPost))
+	mux.HandleFunc("POST /simulate", server.Auth(server.SimulateHandler))
+	mux.HandleFunc("GET /status", server.Auth(server.StatusHandler))
+	mux.HandleFunc("GET /glucose", server.Auth(server.GlucoseHandler))
 	httpServer := &http.Server{
 		Handler: mux,
 		Addr:    ":8080",
 	}
 	server.server = httpServer
 
-	server.bearerToken = input.BearerToken
-
 	return server, nil
 }
 
+// Auth resolves the user from the request's Bearer Token into the request context
 func (s *Server) Auth(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		headerSlice := strings.Split(authHeader, "Bearer ")
-		if authHeader == "" || len(headerSlice) != 2 || headerSlice[1] != s.bearerToken {
+		if authHeader == "" || len(headerSlice) != 2 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		u, ok := s.users[headerSlice[1]]
+		if !ok {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		handler(w, r)
+		handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, u)))
 	}
 }
 
 func (s *Server) Start() {
+	for _, u := range s.users {
+		// Blood Glucose is only entered manually without a CGM, so there is nothing to poll
+		if _, ok := u.glucoseSource.(cgm.ManualSource); !ok {
+			go u.glucosePoller.Run(context.Background())
+		}
+	}
+
 	err := s.server.ListenAndServe()
 	if err != nil {
 		log.Fatalln(err)
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+type fakeSource struct {
+	reading cgm.Reading
+	err     error
+}
+
+func (f *fakeSource) CurrentReading(ctx context.Context) (cgm.Reading, error) {
+	return f.reading, f.err
+}
+
+func (f *fakeSource) Name() string {
+	return "fake"
+}
+
+type testServer struct {
+	*Server
+	now time.Time
+}
+
+// newTestServer returns a Server for users with the given Bearer Tokens, each with their files in a
+// temporary directory and a fake Glucose Source reading 150 mg/dL a minute ago
+func newTestServer(t *testing.T, tokens ...string) *testServer {
+	ts := &testServer{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	var users []UserInput
+	for _, token := range tokens {
+		dir := t.TempDir()
+		users = append(users, UserInput{
+			Name:            token,
+			BearerToken:     token,
+			FilePath:        filepath.Join(dir, "me.json"),
+			LogbookFilePath: filepath.Join(dir, "logbook.json"),
+			HistoryFilePath: filepath.Join(dir, "history.json"),
+			GlucoseSource: &fakeSource{reading: cgm.Reading{
+				Time:        ts.now.Add(-time.Minute),
+				ValueInMgDl: 150,
+				TrendMethod: cgm.ArrowTrend,
+			}},
+		})
+	}
+
+	var err error
+	ts.Server, err = NewServer(ServerInput{
+		Users: users,
+		Now:   func() time.Time { return ts.now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// do sends a request as the user with the Bearer Token, and decodes a JSON response into output (if set)
+func (ts *testServer) do(t *testing.T, token string, method string, path string, body string, output any, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response := httptest.NewRecorder()
+	ts.server.Handler.ServeHTTP(response, request)
+
+	if output != nil && response.Code == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(output); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return response
+}
+
+// onboard sets the user's settings: 1 unit per 10 g of carbs, a target of 100 mg/dL, and 1 unit per 50 mg/dL
+func (ts *testServer) onboard(t *testing.T, token string) {
+	t.Helper()
+	response := ts.do(t, token, http.MethodPatch, "/me", `{"insulin_to_carb_ratio": 10, "target_blood_glucose": 100, "insulin_sensitivity_factor": 50}`, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	for _, header := range []string{"", "Bearer sam", "alex"} {
+		request := httptest.NewRequest(http.MethodGet, "/me", nil)
+		request.Header.Set("Authorization", header)
+		response := httptest.NewRecorder()
+		ts.server.Handler.ServeHTTP(response, request)
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %d", header, response.Code)
+		}
+	}
+}
+
+func TestUsersAreIsolated(t *testing.T) {
+	ts := newTestServer(t, "alex", "sam")
+	ts.onboard(t, "alex")
+	ts.onboard(t, "sam")
+
+	var dose DoseOutput
+	if response := ts.do(t, "alex", http.MethodPost, "/dose", `{"total_grams_of_carbs": 20}`, &dose); response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+
+	var doses DosesOutput
+	ts.do(t, "sam", http.MethodGet, "/doses", "", &doses)
+	if doses.Total != 0 {
+		t.Errorf("expected sam to have no doses, got %d", doses.Total)
+	}
+	if response := ts.do(t, "sam", http.MethodPost, "/doses/"+dose.Id+"/confirm", "", nil); response.Code != http.StatusNotFound {
+		t.Errorf("expected sam to not find alex's dose, got %d", response.Code)
+	}
+	ts.do(t, "alex", http.MethodGet, "/doses", "", &doses)
+	if doses.Total != 1 || doses.Doses[0].Id != dose.Id {
+		t.Errorf("expected alex's dose, got %+v", doses)
+	}
+
+	ts.do(t, "sam", http.MethodPatch, "/me", `{"insulin_to_carb_ratio": 5}`, nil)
+	var me Me
+	ts.do(t, "alex", http.MethodGet, "/me", "", &me)
+	if ratio := me.InsulinToCarbRatio.GetAtTime(ts.now); ratio != 10 {
+		t.Errorf("expected alex's ratio to stay 10, got %f", ratio)
+	}
+}
+
+func TestNewServerRejectsDuplicateTokens(t *testing.T) {
+	var users []UserInput
+	for _, name := range []string{"alex", "sam"} {
+		dir := t.TempDir()
+		users = append(users, UserInput{
+			Name:            name,
+			BearerToken:     "token",
+			FilePath:        filepath.Join(dir, "me.json"),
+			LogbookFilePath: filepath.Join(dir, "logbook.json"),
+			HistoryFilePath: filepath.Join(dir, "history.json"),
+		})
+	}
+	_, err := NewServer(ServerInput{Users: users})
+	if err == nil {
+		t.Errorf("expected an error for a duplicate bearer token")
+	}
+}
+
+func TestNewServerRejectsEmptyFilePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, input := range []UserInput{
+		{FilePath: "", LogbookFilePath: filepath.Join(dir, "logbook.json"), HistoryFilePath: filepath.Join(dir, "history.json")},
+		{FilePath: filepath.Join(dir, "me.json"), LogbookFilePath: "", HistoryFilePath: filepath.Join(dir, "history.json")},
+		{FilePath: filepath.Join(dir, "me.json"), LogbookFilePath: filepath.Join(dir, "logbook.json"), HistoryFilePath: ""},
+	} {
+		input.Name = "alex"
+		input.BearerToken = "alex"
+		_, err := NewServer(ServerInput{Users: []UserInput{input}})
+		if err == nil {
+			t.Errorf("expected an error for %+v", input)
+		}
+	}
+}
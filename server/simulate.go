@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kennedyjustin/BolusGPT/bolus"
+)
+
+type SimulateInput struct {
+	DoseInput
+	UnitsOfInsulin            *float32 `json:"units_of_insulin"`
+	ExtendedUnitsOfInsulin    float32  `json:"extended_units_of_insulin"`
+	ExtendedDurationInMinutes float32  `json:"extended_duration_in_minutes"`
+}
+
+type SimulateOutput struct {
+	// Projected Blood Glucose every 5 minutes, starting now
+	Points []GlucosePointOutput
+	// Lowest projected Blood Glucose
+	Nadir GlucosePointOutput
+	// Highest projected Blood Glucose
+	Peak GlucosePointOutput
+	// Set when the Nadir is under LowThreshold
+	PredictedLow bool
+	// Blood Glucose under which a low is predicted
+	LowThreshold float32
+	// Unit of every Blood Glucose value
+	Unit bolus.GlucoseUnit
+}
+
+type GlucosePointOutput struct {
+	Time         time.Time
+	BloodGlucose float32
+}
+
+func (s *Server) SimulateHandler(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+
+	decoder := json.NewDecoder(request.Body)
+	input := SimulateInput{}
+	err := decoder.Decode(&input)
+	if err != nil {
+		log.Println(err)
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if input.UnitsOfInsulin == nil {
+		writeDoseError(response, bolus.ValidationErrors{{Field: "units_of_insulin", Message: "is required"}})
+		return
+	}
+
+	reading, readingErr := u.cgmReading(request.Context(), input.DoseInput)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var me Me
+	u.db.Read(func(data *Me) {
+		me = *data
+	})
+
+	doseInput, _, err := u.newDoseInput(me, input.DoseInput, reading, readingErr, s.now())
+	if err != nil {
+		writeDoseError(response, err)
+		return
+	}
+
+	simulation, err := bolus.Simulate(bolus.SimulationInput{
+		DoseInput:                   doseInput,
+		UnitsOfInsulin:              *input.UnitsOfInsulin,
+		ExtendedUnitsOfInsulin:      input.ExtendedUnitsOfInsulin,
+		ExtendedDurationInMinutes:   input.ExtendedDurationInMinutes,
+		CarbAbsorptionTimeInMinutes: input.carbAbsorptionTimeInMinutes(me),
+	})
+	if err != nil {
+		writeDoseError(response, validationErrorsInGlucoseUnit(err, me.glucoseUnit()))
+		return
+	}
+
+	glucoseUnit := me.glucoseUnit()
+	point := func(p bolus.GlucosePoint) GlucosePointOutput {
+		return GlucosePointOutput{Time: p.Time, BloodGlucose: glucoseUnit.FromMgDl(p.BloodGlucoseLevelInMgDl)}
+	}
+	output := SimulateOutput{
+		Nadir:        point(simulation.Nadir),
+		Peak:         point(simulation.Peak),
+		PredictedLow: simulation.PredictedLow,
+		LowThreshold: glucoseUnit.FromMgDl(simulation.LowThresholdInMgDl),
+		Unit:         glucoseUnit,
+	}
+	for _, p := range simulation.Points {
+		output.Points = append(output.Points, point(p))
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(output)
+}
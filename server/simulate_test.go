@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSimulateHandler(t *testing.T) {
+	ts := newTestServer(t, "alex")
+	ts.onboard(t, "alex")
+
+	var simulation SimulateOutput
+	response := ts.do(t, "alex", http.MethodPost, "/simulate", `{"total_grams_of_carbs": 20, "units_of_insulin": 10}`, &simulation)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	if !simulation.PredictedLow || len(simulation.Points) == 0 {
+		t.Errorf("expected a low to be predicted for 10 units, got %+v", simulation)
+	}
+
+	if response := ts.do(t, "alex", http.MethodPost, "/simulate", `{"total_grams_of_carbs": 20}`, nil); response.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without units of insulin, got %d", response.Code)
+	}
+}
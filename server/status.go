@@ -0,0 +1,28 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+)
+
+type StatusOutput struct {
+	// Name of the Glucose Source, for example "dexcom"
+	GlucoseSource string
+	// Connection to the Glucose Source, unset when it has none (for example "manual")
+	Connection *cgm.Status
+}
+
+func (s *Server) StatusHandler(response http.ResponseWriter, request *http.Request) {
+	u := userFrom(request.Context())
+
+	output := StatusOutput{GlucoseSource: u.glucoseSource.Name()}
+	if reporter, ok := u.glucoseSource.(cgm.StatusReporter); ok {
+		status := reporter.Status()
+		output.Connection = &status
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(output)
+}
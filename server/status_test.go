@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStatusHandler(t *testing.T) {
+	ts := newTestServer(t, "alex")
+
+	var status StatusOutput
+	response := ts.do(t, "alex", http.MethodGet, "/status", "", &status)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body)
+	}
+	// The fake Glucose Source does not report its connection
+	if status.GlucoseSource != "fake" || status.Connection != nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
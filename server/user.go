@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/kennedyjustin/BolusGPT/cgm"
+	"github.com/kennedyjustin/BolusGPT/jsonfile"
+)
+
+// A user of the server, with their own settings, Logbook, History, and Glucose Source
+type user struct {
+	// Serializes the user's requests. Requests that only read the Glucose Source (which locks itself) and
+	// the settings, like GET /glucose, do not take it.
+	mu            sync.Mutex
+	name          string
+	db            *jsonfile.JSONFile[Me]
+	logbook       *jsonfile.JSONFile[Logbook]
+	history       *jsonfile.JSONFile[History]
+	glucoseSource cgm.Source
+	// Polls glucoseSource in the background, caching its readings
+	glucosePoller *cgm.Poller
+}
+
+type UserInput struct {
+	Name string
+	// Authenticates the user's requests, unique to the user
+	BearerToken     string
+	FilePath        string
+	LogbookFilePath string
+	HistoryFilePath string
+	// Source of the user's current Blood Glucose (defaults to cgm.ManualSource)
+	GlucoseSource cgm.Source
+}
+
+func (s *Server) newUser(input UserInput) (*user, error) {
+	// jsonfile would write to temporary files in the working directory instead
+	if input.FilePath == "" || input.LogbookFilePath == "" || input.HistoryFilePath == "" {
+		message := "file path, logbook file path, and history file path required"
+		if input.Name != "" {
+			message += " for user " + input.Name
+		}
+		return nil, errors.New(message)
+	}
+
+	u := &user{name: input.Name}
+
+	db, err := jsonfile.LoadOrNew[Me](input.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	u.db = db
+
+	logbook, err := jsonfile.LoadOrNew[Logbook](input.LogbookFilePath)
+	if err != nil {
+		return nil, err
+	}
+	u.logbook = logbook
+
+	history, err := jsonfile.LoadOrNew[History](input.HistoryFilePath)
+	if err != nil {
+		return nil, err
+	}
+	u.history = history
+
+	err = u.migrateLastBolus(input.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	u.glucoseSource = input.GlucoseSource
+	if u.glucoseSource == nil {
+		u.glucoseSource = cgm.ManualSource{}
+	}
+	u.glucosePoller = cgm.NewPoller(cgm.PollerInput{
+		Source:   u.glucoseSource,
+		Interval: s.pollInterval,
+		Now:      s.now,
+	})
+
+	return u, nil
+}
+
+type userContextKey struct{}
+
+// userFrom returns the user Auth resolved the request to
+func userFrom(ctx context.Context) *user {
+	return ctx.Value(userContextKey{}).(*user)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/kennedyjustin/BolusGPT/server"
+)
+
+// Users is the user registry, read from USERS_FILE
+type Users struct {
+	Users []UserConfig `json:"users"`
+}
+
+type UserConfig struct {
+	// Also the directory the user's files are stored in
+	Name        string `json:"name"`
+	BearerToken string `json:"bearer_token"`
+	GlucoseSourceConfig
+}
+
+// loadUsers reads the user registry, creating a directory for each user's files
+func loadUsers(path string) ([]server.UserInput, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var users Users
+	err = json.Unmarshal(b, &users)
+	if err != nil {
+		return nil, err
+	}
+	if len(users.Users) == 0 {
+		return nil, errors.New("no users in " + path)
+	}
+
+	var inputs []server.UserInput
+	names := map[string]bool{}
+	for _, user := range users.Users {
+		if user.Name == "" || user.Name == "." || user.Name == ".." || filepath.Base(user.Name) != user.Name {
+			return nil, errors.New("user name must be a valid directory name: " + user.Name)
+		}
+		// Users with the same name would share their files
+		if names[user.Name] {
+			return nil, errors.New("user name is not unique: " + user.Name)
+		}
+		names[user.Name] = true
+		err = os.MkdirAll(user.Name, 0700)
+		if err != nil {
+			return nil, err
+		}
+
+		glucoseSource, err := newGlucoseSource(user.GlucoseSourceConfig)
+		if err != nil {
+			return nil, errors.New(user.Name + ": " + err.Error())
+		}
+		inputs = append(inputs, server.UserInput{
+			Name:            user.Name,
+			BearerToken:     user.BearerToken,
+			FilePath:        filepath.Join(user.Name, Filepath),
+			LogbookFilePath: filepath.Join(user.Name, LogbookFilepath),
+			HistoryFilePath: filepath.Join(user.Name, HistoryFilepath),
+			GlucoseSource:   glucoseSource,
+		})
+	}
+	return inputs, nil
+}